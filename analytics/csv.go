@@ -0,0 +1,48 @@
+package analytics
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+)
+
+// WriteCSV writes rows as CSV with a header, one column per dimension grouped
+// by followed by the statistics.
+func WriteCSV(w io.Writer, rows []Row, groupBy []Dimension) error {
+	out := csv.NewWriter(w)
+	header := make([]string, 0, len(groupBy)+5)
+	for _, dim := range groupBy {
+		header = append(header, string(dim))
+	}
+	header = append(header, "observed_seconds", "busy_seconds", "busy_percent", "sessions", "median_session_seconds")
+	if err := out.Write(header); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		record := make([]string, 0, len(header))
+		for _, dim := range groupBy {
+			switch dim {
+			case DimOutlet:
+				record = append(record, row.Outlet)
+			case DimStation:
+				record = append(record, row.Station)
+			case DimHour:
+				record = append(record, strconv.Itoa(*row.Hour))
+			case DimWeekday:
+				record = append(record, row.Weekday)
+			}
+		}
+		record = append(record,
+			strconv.FormatInt(row.ObservedSeconds, 10),
+			strconv.FormatInt(row.BusySeconds, 10),
+			strconv.FormatFloat(row.BusyPercent, 'f', 2, 64),
+			strconv.Itoa(row.Sessions),
+			strconv.FormatInt(row.MedianSessionSeconds, 10),
+		)
+		if err := out.Write(record); err != nil {
+			return err
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
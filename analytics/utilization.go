@@ -0,0 +1,202 @@
+// Package analytics computes occupancy statistics from the outlet history and
+// the session log.
+package analytics
+
+import (
+	"charge-monitor/cache"
+	"charge-monitor/history"
+	"charge-monitor/session"
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Dimension is what utilization can be grouped by.
+type Dimension string
+
+const (
+	DimOutlet  Dimension = "outlet"
+	DimStation Dimension = "station"
+	// DimHour groups by the local hour of day.
+	DimHour Dimension = "hour"
+	// DimWeekday groups by the local day of week.
+	DimWeekday Dimension = "weekday"
+)
+
+// ParseDimensions parses a comma-separated list of dimensions.
+func ParseDimensions(value string) ([]Dimension, error) {
+	var dims []Dimension
+	for name := range strings.SplitSeq(value, ",") {
+		dim := Dimension(strings.TrimSpace(name))
+		switch dim {
+		case DimOutlet, DimStation, DimHour, DimWeekday:
+		default:
+			return nil, fmt.Errorf("unknown dimension %q", dim)
+		}
+		if slices.Contains(dims, dim) {
+			return nil, fmt.Errorf("duplicate dimension %q", dim)
+		}
+		dims = append(dims, dim)
+	}
+	return dims, nil
+}
+
+// Outlet is the history of one outlet.
+type Outlet struct {
+	ID        string
+	StationID string
+	// Samples are in time order and may start before the range, so the state
+	// at its start is known.
+	Samples []history.Sample
+}
+
+type Options struct {
+	// From and To bound the range in Unix seconds, To excluded.
+	From, To int64
+	GroupBy  []Dimension
+	// Location is the time zone of hours and weekdays; nil for the local one.
+	Location *time.Location
+	// MaxGap is how long a sample is taken to last without a newer one. Time
+	// beyond it, like time offline or unknown, is not observed.
+	MaxGap time.Duration
+}
+
+// Row is the utilization of one group. Only the fields of the dimensions
+// grouped by are set.
+type Row struct {
+	Outlet  string `json:"outlet,omitempty"`
+	Station string `json:"station,omitempty"`
+	Hour    *int   `json:"hour,omitempty"`
+	Weekday string `json:"weekday,omitempty"`
+	// ObservedSeconds is the time the outlets were known to be idle or busy.
+	ObservedSeconds int64 `json:"observed_seconds"`
+	// BusySeconds is the part of it they were charging or finished but still
+	// plugged in.
+	BusySeconds int64   `json:"busy_seconds"`
+	BusyPercent float64 `json:"busy_percent"`
+	// Sessions counts the finished sessions that started in the group.
+	Sessions             int   `json:"sessions"`
+	MedianSessionSeconds int64 `json:"median_session_seconds"`
+
+	key       groupKey
+	durations []int64
+}
+
+type groupKey struct {
+	outlet, station string
+	hour, weekday   int
+}
+
+// Utilization groups the observed and busy time of the outlets in the range,
+// and the sessions starting in it, ordered by the dimensions grouped by.
+func Utilization(outlets []Outlet, sessions []session.Session, opts Options) []Row {
+	location := cmp.Or(opts.Location, time.Local)
+	maxGap := int64(opts.MaxGap / time.Second)
+	groups := make(map[groupKey]*Row)
+	group := func(outletId, stationId string, at int64) *Row {
+		local := time.Unix(at, 0).In(location)
+		key := groupKey{hour: -1, weekday: -1}
+		for _, dim := range opts.GroupBy {
+			switch dim {
+			case DimOutlet:
+				key.outlet = outletId
+			case DimStation:
+				key.station = stationId
+			case DimHour:
+				key.hour = local.Hour()
+			case DimWeekday:
+				key.weekday = int(local.Weekday())
+			}
+		}
+		row := groups[key]
+		if row == nil {
+			row = &Row{Outlet: key.outlet, Station: key.station, key: key}
+			if key.hour >= 0 {
+				hour := key.hour
+				row.Hour = &hour
+			}
+			if key.weekday >= 0 {
+				row.Weekday = time.Weekday(key.weekday).String()
+			}
+			groups[key] = row
+		}
+		return row
+	}
+
+	for _, outlet := range outlets {
+		for i, sample := range outlet.Samples {
+			if sample.State != cache.StateIdle && !session.Active(sample) {
+				continue
+			}
+			end := sample.Time + maxGap
+			if i+1 < len(outlet.Samples) {
+				end = min(end, outlet.Samples[i+1].Time)
+			}
+			start, end := max(sample.Time, opts.From), min(end, opts.To)
+			busy := session.Active(sample)
+			// Split at hour boundaries, which also separate days.
+			for start < end {
+				local := time.Unix(start, 0).In(location)
+				next := time.Date(local.Year(), local.Month(), local.Day(), local.Hour()+1, 0, 0, 0, location).Unix()
+				next = min(max(next, start+1), end)
+				row := group(outlet.ID, outlet.StationID, start)
+				row.ObservedSeconds += next - start
+				if busy {
+					row.BusySeconds += next - start
+				}
+				start = next
+			}
+		}
+	}
+	for _, s := range sessions {
+		if s.Ongoing || s.Start < opts.From || s.Start >= opts.To {
+			continue
+		}
+		row := group(s.OutletID, s.StationID, s.Start)
+		row.Sessions++
+		row.durations = append(row.durations, s.DurationSeconds)
+	}
+
+	rows := make([]Row, 0, len(groups))
+	for _, row := range groups {
+		if row.ObservedSeconds > 0 {
+			row.BusyPercent = float64(row.BusySeconds) * 100 / float64(row.ObservedSeconds)
+		}
+		row.MedianSessionSeconds = median(row.durations)
+		rows = append(rows, *row)
+	}
+	slices.SortFunc(rows, func(a, b Row) int {
+		for _, dim := range opts.GroupBy {
+			var c int
+			switch dim {
+			case DimOutlet:
+				c = cmp.Compare(a.key.outlet, b.key.outlet)
+			case DimStation:
+				c = cmp.Compare(a.key.station, b.key.station)
+			case DimHour:
+				c = cmp.Compare(a.key.hour, b.key.hour)
+			case DimWeekday:
+				c = cmp.Compare(a.key.weekday, b.key.weekday)
+			}
+			if c != 0 {
+				return c
+			}
+		}
+		return 0
+	})
+	return rows
+}
+
+func median(values []int64) int64 {
+	if len(values) == 0 {
+		return 0
+	}
+	slices.Sort(values)
+	mid := len(values) / 2
+	if len(values)%2 == 1 {
+		return values[mid]
+	}
+	return (values[mid-1] + values[mid]) / 2
+}
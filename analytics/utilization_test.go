@@ -0,0 +1,107 @@
+package analytics
+
+import (
+	"bytes"
+	"charge-monitor/cache"
+	"charge-monitor/history"
+	"charge-monitor/session"
+	"testing"
+	"time"
+)
+
+func testOutlets() []Outlet {
+	// 1970-01-01 was a Thursday.
+	return []Outlet{
+		{ID: "outlet-1", StationID: "xzy-4", Samples: []history.Sample{
+			{Time: 0, State: cache.StateIdle},
+			{Time: 1800, Watts: 90, State: cache.StateCharging},
+			{Time: 3600, Watts: 90, UsedMinutes: 30, State: cache.StateCharging},
+			{Time: 5400, State: cache.StateIdle},
+		}},
+		{ID: "outlet-2", StationID: "xzy-4", Samples: []history.Sample{
+			{Time: 0, State: cache.StateIdle},
+			{Time: 1800, State: cache.StateOffline},
+			{Time: 3600, UsedMinutes: 10, State: cache.StateFinished},
+		}},
+	}
+}
+
+func TestUtilization_ByOutlet(t *testing.T) {
+	rows := Utilization(testOutlets(), nil, Options{
+		From:     0,
+		To:       7200,
+		GroupBy:  []Dimension{DimOutlet},
+		Location: time.UTC,
+		MaxGap:   time.Hour,
+	})
+	if len(rows) != 2 {
+		t.Fatalf("Expected 2 rows, got %+v", rows)
+	}
+	// Busy from 1800 to 5400, idle the rest of the two hours.
+	if r := rows[0]; r.Outlet != "outlet-1" || r.ObservedSeconds != 7200 || r.BusySeconds != 3600 || r.BusyPercent != 50 {
+		t.Errorf("Unexpected row %+v", r)
+	}
+	// Offline time is not observed, and the last sample lasts until to.
+	if r := rows[1]; r.ObservedSeconds != 5400 || r.BusySeconds != 3600 {
+		t.Errorf("Unexpected row %+v", r)
+	}
+}
+
+func TestUtilization_ByHourWithSessions(t *testing.T) {
+	sessions := []session.Session{
+		{OutletID: "outlet-1", StationID: "xzy-4", Start: 1800, DurationSeconds: 3600},
+		{OutletID: "outlet-1", StationID: "xzy-4", Start: 2400, DurationSeconds: 600},
+		{OutletID: "outlet-2", StationID: "xzy-4", Start: 3000, DurationSeconds: 1200},
+		{OutletID: "outlet-2", StationID: "xzy-4", Start: 3000, Ongoing: true},
+	}
+	rows := Utilization(testOutlets(), sessions, Options{
+		From:     0,
+		To:       7200,
+		GroupBy:  []Dimension{DimStation, DimWeekday, DimHour},
+		Location: time.UTC,
+		MaxGap:   10 * time.Minute,
+	})
+	if len(rows) != 2 {
+		t.Fatalf("Expected 2 rows, got %+v", rows)
+	}
+	first, second := rows[0], rows[1]
+	if first.Station != "xzy-4" || first.Weekday != "Thursday" || first.Hour == nil || *first.Hour != 0 {
+		t.Errorf("Unexpected first group %+v", first)
+	}
+	// Samples last at most 10 minutes without a newer one.
+	if first.ObservedSeconds != 600+600+600 || first.BusySeconds != 600 {
+		t.Errorf("Unexpected first hour %+v", first)
+	}
+	if first.Sessions != 3 || first.MedianSessionSeconds != 1200 {
+		t.Errorf("Expected 3 finished sessions with a median of 1200s, got %d and %d", first.Sessions, first.MedianSessionSeconds)
+	}
+	if *second.Hour != 1 || second.ObservedSeconds != 1800 || second.BusySeconds != 1200 {
+		t.Errorf("Unexpected second hour %+v", second)
+	}
+}
+
+func TestParseDimensions(t *testing.T) {
+	dims, err := ParseDimensions("station, hour")
+	if err != nil || len(dims) != 2 || dims[1] != DimHour {
+		t.Errorf("Expected station and hour, got %v, %v", dims, err)
+	}
+	for _, value := range []string{"campus", "hour,hour", ""} {
+		if _, err := ParseDimensions(value); err == nil {
+			t.Errorf("Expected %q to be rejected", value)
+		}
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	hour := 8
+	rows := []Row{{Station: "xzy-4", Hour: &hour, ObservedSeconds: 3600, BusySeconds: 900, BusyPercent: 25, Sessions: 2, MedianSessionSeconds: 1500}}
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, rows, []Dimension{DimStation, DimHour}); err != nil {
+		t.Fatalf("WriteCSV failed: %v", err)
+	}
+	want := "station,hour,observed_seconds,busy_seconds,busy_percent,sessions,median_session_seconds\n" +
+		"xzy-4,8,3600,900,25.00,2,1500\n"
+	if got := buf.String(); got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
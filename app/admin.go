@@ -0,0 +1,108 @@
+package app
+
+import (
+	"charge-monitor/cache"
+	"charge-monitor/webhook"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// adminMiddleware requires the admin token as a bearer token.
+func (a *App) adminMiddleware(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a.adminToken == "" {
+			writeError(w, http.StatusForbidden, "admin API is disabled")
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(a.adminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, "invalid admin token")
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// dispatchWebhooks hands every typed change from the cache to the webhooks.
+func (a *App) dispatchWebhooks(c cache.Change) {
+	if len(c.Events) == 0 {
+		return
+	}
+	ref, _ := a.getCatalog().Outlet(c.OutletID)
+	view := newOutletView(ref, c.Current)
+	for _, event := range c.Events {
+		a.webhooks.Dispatch(webhook.Event{Type: event, OutletID: c.OutletID, StationID: view.StationID, Outlet: view, Remote: c.Remote})
+	}
+}
+
+// redact hides webhook secrets from API responses.
+func redact(s webhook.Subscription) webhook.Subscription {
+	s.Secret = ""
+	return s
+}
+
+func (a *App) handleWebhooks(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		subscriptions := a.webhooks.Subscriptions()
+		for i := range subscriptions {
+			subscriptions[i] = redact(subscriptions[i])
+		}
+		writeJSON(w, http.StatusOK, subscriptions)
+	case http.MethodPost:
+		if a.fixedWebhooks {
+			writeError(w, http.StatusConflict, "webhooks cannot be registered at runtime with shared storage, add them to the config file")
+			return
+		}
+		var s webhook.Subscription
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&s); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid webhook: "+err.Error())
+			return
+		}
+		if s.ID == "" {
+			id := make([]byte, 8)
+			rand.Read(id)
+			s.ID = hex.EncodeToString(id)
+		}
+		if err := s.Validate(); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		err := a.webhooks.Add(s)
+		switch {
+		case errors.Is(err, webhook.ErrExists):
+			writeError(w, http.StatusConflict, err.Error())
+		case err != nil:
+			writeError(w, http.StatusInternalServerError, err.Error())
+		default:
+			s.Static = false
+			writeJSON(w, http.StatusCreated, redact(s))
+		}
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (a *App) handleWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	err := a.webhooks.Remove(r.PathValue("id"))
+	switch {
+	case errors.Is(err, webhook.ErrNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, webhook.ErrStatic):
+		writeError(w, http.StatusConflict, err.Error())
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
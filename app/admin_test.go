@@ -0,0 +1,99 @@
+package app
+
+import (
+	"charge-monitor/cache"
+	"charge-monitor/webhook"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func adminRequest(t *testing.T, handler http.Handler, method, path, token, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestAdmin_RequiresToken(t *testing.T) {
+	a := newTestApp()
+	routes := a.routes()
+	if w := adminRequest(t, routes, "GET", "/admin/webhooks", "", ""); w.Code != http.StatusForbidden {
+		t.Errorf("Expected status code 403 without an admin token configured, got %d", w.Code)
+	}
+	a.adminToken = "letmein"
+	if w := adminRequest(t, routes, "GET", "/admin/webhooks", "guess", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status code 401 for a wrong token, got %d", w.Code)
+	}
+	if w := adminRequest(t, routes, "GET", "/admin/webhooks", "letmein", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected status code 200, got %d", w.Code)
+	}
+}
+
+func TestAdmin_WebhookLifecycle(t *testing.T) {
+	deliveries := make(chan webhook.Payload, 4)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var payload webhook.Payload
+		json.Unmarshal(body, &payload)
+		deliveries <- payload
+	}))
+	defer receiver.Close()
+	a := newTestApp()
+	defer a.Close()
+	a.adminToken = "letmein"
+	routes := a.routes()
+
+	w := adminRequest(t, routes, "POST", "/admin/webhooks", "letmein", `{"url":"`+receiver.URL+`","secret":"s3cret","stations":["xzy-4"]}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status code 201, got %d: %s", w.Code, w.Body)
+	}
+	var created webhook.Subscription
+	json.Unmarshal(w.Body.Bytes(), &created)
+	if created.ID == "" || created.Secret != "" {
+		t.Errorf("Expected a generated id and a redacted secret, got %+v", created)
+	}
+	if w := adminRequest(t, routes, "POST", "/admin/webhooks", "letmein", `{"url":"not a url"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code 400 for an invalid webhook, got %d", w.Code)
+	}
+
+	a.cache.Set("outlet-7", cache.OutletInfo{Power: "88W", State: cache.StateCharging})
+	a.cache.Set("outlet-7", cache.OutletInfo{Power: "0W", State: cache.StateFinished})
+	select {
+	case payload := <-deliveries:
+		if payload.Event != cache.EventFinishedCharging || payload.OutletID != "outlet-7" || payload.StationID != "xzy-4" {
+			t.Errorf("Unexpected payload %+v", payload)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the webhook")
+	}
+
+	if w := adminRequest(t, routes, "DELETE", "/admin/webhooks/"+created.ID, "letmein", ""); w.Code != http.StatusNoContent {
+		t.Errorf("Expected status code 204, got %d", w.Code)
+	}
+	if w := adminRequest(t, routes, "DELETE", "/admin/webhooks/"+created.ID, "letmein", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status code 404, got %d", w.Code)
+	}
+}
+
+func TestAdmin_NoRuntimeWebhooksWithSharedStorage(t *testing.T) {
+	a := newTestApp()
+	a.adminToken = "letmein"
+	a.fixedWebhooks = true
+	routes := a.routes()
+
+	if w := adminRequest(t, routes, "POST", "/admin/webhooks", "letmein", `{"url":"https://example.com/hook"}`); w.Code != http.StatusConflict {
+		t.Errorf("Expected status code 409, got %d", w.Code)
+	}
+	if w := adminRequest(t, routes, "GET", "/admin/webhooks", "letmein", ""); w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != "[]" {
+		t.Errorf("Expected no webhooks, got %d: %s", w.Code, w.Body)
+	}
+}
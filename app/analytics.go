@@ -0,0 +1,91 @@
+package app
+
+import (
+	"charge-monitor/analytics"
+	"charge-monitor/session"
+	"cmp"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type utilizationView struct {
+	From    int64                 `json:"from"`
+	To      int64                 `json:"to"`
+	GroupBy []analytics.Dimension `json:"group_by"`
+	Rows    []analytics.Row       `json:"rows"`
+}
+
+// getUtilization reports how busy outlets were in [from, to), by default the
+// last 7 days, grouped by station unless group_by says otherwise. It answers
+// with CSV for format=csv or a client that accepts text/csv, JSON otherwise.
+func (a *App) getUtilization(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	from, to, err := parseRange(r, 7*24*time.Hour)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	groupBy, err := analytics.ParseDimensions(cmp.Or(query.Get("group_by"), string(analytics.DimStation)))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid group_by: "+err.Error())
+		return
+	}
+	location := time.Local
+	if tz := query.Get("tz"); tz != "" {
+		if location, err = time.LoadLocation(tz); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid tz: "+err.Error())
+			return
+		}
+	}
+	format := query.Get("format")
+	if format == "" && strings.Contains(r.Header.Get("Accept"), "text/csv") {
+		format = "csv"
+	}
+	if format != "" && format != "csv" && format != "json" {
+		writeError(w, http.StatusBadRequest, "invalid format: must be json or csv")
+		return
+	}
+
+	filter := newEventFilter(query)
+	catalog := a.getCatalog()
+	var outlets []analytics.Outlet
+	for _, id := range catalog.OutletIDs() {
+		ref, _ := catalog.Outlet(id)
+		var stationId string
+		if ref.Station != nil {
+			stationId = ref.Station.ID
+		}
+		if !filter.matchOutlet(id, stationId) {
+			continue
+		}
+		// Start one gap early to know the state at from.
+		samples := a.history.Range(id, from-int64(a.utilizationGap/time.Second), to)
+		outlets = append(outlets, analytics.Outlet{ID: id, StationID: stationId, Samples: samples})
+	}
+	var sessions []session.Session
+	for _, s := range a.sessionLog.Range(from, to) {
+		if filter.matchOutlet(s.OutletID, s.StationID) {
+			sessions = append(sessions, s)
+		}
+	}
+	rows := analytics.Utilization(outlets, sessions, analytics.Options{
+		From:     from,
+		To:       to,
+		GroupBy:  groupBy,
+		Location: location,
+		MaxGap:   a.utilizationGap,
+	})
+
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="utilization.csv"`)
+		w.WriteHeader(http.StatusOK)
+		if err := analytics.WriteCSV(w, rows, groupBy); err != nil {
+			slog.Error("Failed to write utilization CSV", "error", err)
+		}
+		return
+	}
+	writeJSON(w, http.StatusOK, utilizationView{From: from, To: to, GroupBy: groupBy, Rows: rows})
+}
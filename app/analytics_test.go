@@ -0,0 +1,51 @@
+package app
+
+import (
+	"charge-monitor/cache"
+	"charge-monitor/history"
+	"charge-monitor/session"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestGetUtilization(t *testing.T) {
+	a := newTestApp()
+	a.history.Append("outlet-1", history.Sample{Time: 0, State: cache.StateIdle})
+	a.history.Append("outlet-1", history.Sample{Time: 600, Watts: 90, State: cache.StateCharging})
+	a.history.Append("outlet-1", history.Sample{Time: 1200, State: cache.StateIdle})
+	a.history.Append("outlet-7", history.Sample{Time: 0, State: cache.StateIdle})
+	a.sessionLog.Append(session.Session{OutletID: "outlet-1", StationID: "xzy-4", Start: 600, End: 1200, DurationSeconds: 600})
+
+	rec := doRequest(t, a.routes(), http.MethodGet, "/analytics/utilization?from=0&to=1800&tz=UTC", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var view utilizationView
+	if err := json.Unmarshal(rec.Body.Bytes(), &view); err != nil {
+		t.Fatalf("Response is not valid JSON: %v", err)
+	}
+	if len(view.Rows) != 1 {
+		t.Fatalf("Expected one row per station, got %+v", view.Rows)
+	}
+	// outlet-7 has a single sample, which lasts for the 15 minute gap.
+	if r := view.Rows[0]; r.Station != "xzy-4" || r.ObservedSeconds != 1800+900 || r.BusySeconds != 600 || r.Sessions != 1 {
+		t.Errorf("Unexpected station row %+v", r)
+	}
+
+	rec = doRequest(t, a.routes(), http.MethodGet, "/analytics/utilization?from=0&to=1800&tz=UTC&group_by=outlet&outlet=outlet-1&format=csv", "")
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/csv") {
+		t.Fatalf("Expected CSV, got %q: %s", ct, rec.Body)
+	}
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[1], "outlet-1,1800,600,33.33,1,600") {
+		t.Errorf("Unexpected CSV %q", rec.Body.String())
+	}
+
+	for _, query := range []string{"group_by=campus", "tz=Mars/Olympus", "format=xml"} {
+		if rec := doRequest(t, a.routes(), http.MethodGet, "/analytics/utilization?"+query, ""); rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected %s to be rejected, got %d", query, rec.Code)
+		}
+	}
+}
@@ -2,43 +2,509 @@ package app
 
 import (
 	"charge-monitor/cache"
+	"charge-monitor/config"
+	"charge-monitor/history"
+	"charge-monitor/leader"
+	"charge-monitor/notify"
 	"charge-monitor/query"
+	"charge-monitor/redis"
+	"charge-monitor/session"
+	"charge-monitor/tariff"
+	"charge-monitor/watch"
+	"charge-monitor/webhook"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
 	"log/slog"
 	"net/http"
+	"net/netip"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 )
 
 type App struct {
-	outlets         []string
-	pollingInterval time.Duration
-	httpAddress     string
-	cache           cache.Cache
+	catalog         *config.Catalog
+	catalogMu       sync.RWMutex
+	requestInterval time.Duration
+	concurrency     int
+	retry           retryPolicy
+	offlineAfter    int
+	polling         bool
+	// elector decides whether this instance polls; nil to always poll.
+	elector          leader.Elector
+	campaignInterval time.Duration
+	httpAddress      string
+	cache            cache.Cache
+	history          history.Store
+	sessions         *session.Tracker
+	sessionLog       session.Store
+	// tariffs prices charging by tariff name.
+	tariffs map[string]*tariff.Tariff
+	// utilizationGap is how long a sample counts towards utilization and
+	// energy without a newer one.
+	utilizationGap time.Duration
+	// snapshotPath is where the cache is persisted, empty to disable.
+	snapshotPath     string
+	snapshotInterval time.Duration
+	shutdownTimeout  time.Duration
+	providers        map[string]query.Provider
+	events           *broker
+	webhooks         *webhook.Dispatcher
+	// fixedWebhooks rejects registering webhooks through the admin API, as
+	// with shared storage they would exist on one replica only.
+	fixedWebhooks bool
+	// adminToken guards the admin API, which is disabled when it is empty.
+	adminToken string
+	notifiers  notify.Channels
+	watches    watch.Store
+	watchTTL   time.Duration
+	// watchMaxTTL caps the TTL a client may ask for.
+	watchMaxTTL time.Duration
+	// watchClients limits how many watches each client creates, as anyone
+	// can make the service send mail or requests on their behalf.
+	watchClients *clientLimiter
+	// trustedProxies are the load balancers whose forwarding headers name
+	// the client.
+	trustedProxies []netip.Prefix
+	stalls         stallTracker
+	// stallAfter is how long the used minutes of a charging outlet must stay
+	// the same for its session to count as finished.
+	stallAfter time.Duration
+	// notifications tracks changes queued for firing watches and messages
+	// being sent, so Close can wait for them. Once notifyClosed is set no
+	// more are started.
+	notifications sync.WaitGroup
+	notifyMu      sync.Mutex
+	notifyClosed  bool
+	// watchQueue holds the changes that may fire watches, as looking watches
+	// up can take a round trip to Redis.
+	watchQueue chan watchFiring
+	// requests is the context of upstream requests, which Run cancels when
+	// they outlast the shutdown timeout.
+	requests      context.Context
+	abortRequests context.CancelFunc
 }
 
-func NewApp(outlets []string, pollingInterval int64, httpAddress string) *App {
-	return &App{
-		outlets:         outlets,
-		pollingInterval: time.Duration(pollingInterval) * time.Millisecond,
-		httpAddress:     httpAddress,
-		cache:           cache.NewLocalCache(),
+func NewApp(conf *config.Config) (_ *App, err error) {
+	providers := make(map[string]query.Provider)
+	defer func() {
+		if err != nil {
+			for _, provider := range providers {
+				provider.Close()
+			}
+		}
+	}()
+	for name, upstream := range conf.AllProviders() {
+		provider, err := newProvider(name, upstream)
+		if err != nil {
+			return nil, err
+		}
+		if conf.CircuitBreaker.FailureThreshold > 0 {
+			cooldown := time.Duration(cmp.Or(conf.CircuitBreaker.Cooldown, 30000)) * time.Millisecond
+			provider = query.NewBreaker(provider, conf.CircuitBreaker.FailureThreshold, cooldown)
+		}
+		providers[name] = provider
+	}
+	tariffs, err := newTariffs(conf.Tariffs)
+	if err != nil {
+		return nil, err
+	}
+	webhooks, err := newWebhooks(conf.Webhooks)
+	if err != nil {
+		return nil, err
+	}
+	store, samples, sessions, err := newStorage(conf)
+	if err != nil {
+		webhooks.Close()
+		return nil, err
+	}
+	snapshotPath := conf.Snapshot.Path
+	if conf.Storage.Backend == config.StorageFile || conf.Storage.Backend == config.StorageRedis {
+		// Every write already outlives the process.
+		snapshotPath = ""
+	}
+	a := &App{
+		catalog:          config.NewCatalog(conf),
+		requestInterval:  rateLimitInterval(conf),
+		concurrency:      max(conf.PollingConcurrency, 1),
+		retry:            newRetryPolicy(conf.Retry),
+		offlineAfter:     cmp.Or(conf.OfflineAfter, 3),
+		polling:          !conf.DisablePolling,
+		elector:          newElector(conf),
+		campaignInterval: time.Duration(cmp.Or(conf.LeaderElection.Lease, 15000)) * time.Millisecond / 3,
+		httpAddress:      conf.HTTPAddress,
+		cache:            store,
+		history:          samples,
+		sessionLog:       sessions,
+		tariffs:          tariffs,
+		utilizationGap:   time.Duration(cmp.Or(conf.Analytics.MaxGap, 15*60*1000)) * time.Millisecond,
+		snapshotPath:     snapshotPath,
+		snapshotInterval: time.Duration(cmp.Or(conf.Snapshot.Interval, 60000)) * time.Millisecond,
+		shutdownTimeout:  time.Duration(cmp.Or(conf.ShutdownTimeout, 10000)) * time.Millisecond,
+		providers:        providers,
+		events:           newBroker(1024),
+		webhooks:         webhooks,
+		fixedWebhooks:    conf.Storage.Backend == config.StorageRedis,
+		adminToken:       conf.AdminToken,
+		notifiers:        newNotifiers(conf.Notifications),
+		watches:          newWatches(conf),
+		watchTTL:         time.Duration(cmp.Or(conf.Watches.DefaultTTL, 60*60*1000)) * time.Millisecond,
+		watchMaxTTL:      time.Duration(cmp.Or(conf.Watches.MaxTTL, 12*60*60*1000)) * time.Millisecond,
+		watchClients:     newClientLimiter(cmp.Or(conf.Watches.ClientLimit, 20), time.Hour),
+		trustedProxies:   conf.TrustedProxyPrefixes(),
+		stallAfter:       time.Duration(cmp.Or(conf.Watches.StallAfter, 10*60*1000)) * time.Millisecond,
+		watchQueue:       make(chan watchFiring, watchQueueSize),
+	}
+	go a.runWatches()
+	a.requests, a.abortRequests = context.WithCancel(context.Background())
+	a.sessions = session.NewTracker(sessions, a.tariffFor, a.utilizationGap)
+	store.Subscribe(a.publishChange)
+	store.Subscribe(a.recordSample)
+	store.Subscribe(a.dispatchWebhooks)
+	store.Subscribe(a.queueWatches)
+	return a, nil
+}
+
+func newStorage(conf *config.Config) (cache.Cache, history.Store, session.Store, error) {
+	retention := time.Duration(cmp.Or(conf.History.Retention, 48*60*60*1000)) * time.Millisecond
+	sessionRetention := time.Duration(cmp.Or(conf.Sessions.Retention, 30*24*60*60*1000)) * time.Millisecond
+	switch conf.Storage.Backend {
+	case config.StorageFile:
+	case config.StorageRedis:
+		// History and sessions stay per instance; only the outlet status is shared.
+		r := conf.Storage.Redis
+		prefix := cmp.Or(r.Prefix, "charge-monitor:") + "outlet:"
+		store := cache.NewRedisCache(newRedisClient(r), prefix, time.Duration(r.TTL)*time.Millisecond)
+		return store, history.NewMemoryStore(retention), session.NewMemoryStore(sessionRetention), nil
+	default:
+		return cache.NewLocalCache(), history.NewMemoryStore(retention), session.NewMemoryStore(sessionRetention), nil
+	}
+	dir := cmp.Or(conf.Storage.Dir, "data")
+	store, err := cache.NewFileCache(filepath.Join(dir, "cache.jsonl"))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	samples, err := history.NewFileStore(filepath.Join(dir, "history.jsonl"), retention)
+	if err != nil {
+		store.Close()
+		return nil, nil, nil, err
+	}
+	sessions, err := session.NewFileStore(filepath.Join(dir, "sessions.jsonl"), sessionRetention)
+	if err != nil {
+		store.Close()
+		samples.Close()
+		return nil, nil, nil, err
+	}
+	return store, samples, sessions, nil
+}
+
+func newRedisClient(conf config.RedisConfig) *redis.Client {
+	return redis.NewClient(redis.Options{Address: conf.Address, Password: conf.Password, DB: conf.DB})
+}
+
+func newElector(conf *config.Config) leader.Elector {
+	election := conf.LeaderElection
+	switch election.Backend {
+	case config.ElectionFile:
+		return leader.NewFileLock(cmp.Or(election.Path, "leader.lock"))
+	case config.ElectionRedis:
+		key := cmp.Or(conf.Storage.Redis.Prefix, "charge-monitor:") + "leader"
+		return leader.NewLease(newRedisClient(conf.Storage.Redis), key, cmp.Or(election.Lease, 15000))
+	}
+	return nil
+}
+
+// newWatches keeps watches next to the shared cache, so any replica can fire
+// them.
+func newWatches(conf *config.Config) watch.Store {
+	limit := cmp.Or(conf.Watches.Limit, 10000)
+	if conf.Storage.Backend == config.StorageRedis {
+		prefix := cmp.Or(conf.Storage.Redis.Prefix, "charge-monitor:") + "watch:"
+		return watch.NewRedisRegistry(newRedisClient(conf.Storage.Redis), prefix, limit)
+	}
+	return watch.NewRegistry(limit)
+}
+
+func newWebhooks(conf config.WebhooksConfig) (*webhook.Dispatcher, error) {
+	var static []webhook.Subscription
+	for _, hook := range conf.Subscriptions {
+		events := make([]cache.EventType, 0, len(hook.Events))
+		for _, event := range hook.Events {
+			events = append(events, cache.EventType(event))
+		}
+		static = append(static, webhook.Subscription{
+			ID:       hook.ID,
+			URL:      hook.URL,
+			Secret:   hook.Secret,
+			Events:   events,
+			Outlets:  hook.Outlets,
+			Stations: hook.Stations,
+		})
+	}
+	retry := newRetryPolicy(conf.Retry)
+	return webhook.NewDispatcher(webhook.Options{
+		Timeout:    time.Duration(conf.Timeout) * time.Millisecond,
+		Attempts:   retry.attempts,
+		BaseDelay:  retry.baseDelay,
+		MaxDelay:   retry.maxDelay,
+		DeadLetter: conf.DeadLetter,
+		Store:      conf.Store,
+	}, static)
+}
+
+func newNotifiers(conf config.NotificationsConfig) notify.Channels {
+	channels := make(notify.Channels)
+	if conf.Webhook.Enabled {
+		channels["webhook"] = notify.NewHTTP(time.Duration(conf.Webhook.Timeout)*time.Millisecond, conf.Webhook.AllowPrivate)
+	}
+	if conf.SMTP.Host != "" {
+		channels["email"] = notify.NewSMTP(notify.SMTPOptions{
+			Host:     conf.SMTP.Host,
+			Port:     conf.SMTP.Port,
+			Username: conf.SMTP.Username,
+			Password: conf.SMTP.Password,
+			From:     conf.SMTP.From,
+			Timeout:  time.Duration(conf.SMTP.Timeout) * time.Millisecond,
+		})
+	}
+	if conf.ChatBot.URL != "" {
+		channels["chatbot"] = notify.NewChatBot(conf.ChatBot.URL, conf.ChatBot.Token, time.Duration(conf.ChatBot.Timeout)*time.Millisecond)
+	}
+	return channels
+}
+
+func newProvider(name string, conf config.UpstreamConfig) (query.Provider, error) {
+	opts := query.Options{
+		BaseURL:   conf.BaseURL,
+		Timeout:   time.Duration(cmp.Or(conf.Timeout, 10000)) * time.Millisecond,
+		UserAgent: conf.UserAgent,
+	}
+	if conf.Proxy != "" {
+		// Already validated when the config was loaded.
+		proxy, _ := url.Parse(conf.Proxy)
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = http.ProxyURL(proxy)
+		opts.Transport = transport
+	}
+	return query.NewProvider(name, conf.Type, opts)
+}
+
+func (a *App) UpdateCatalog(conf *config.Config) {
+	catalog := config.NewCatalog(conf)
+	a.catalogMu.Lock()
+	defer a.catalogMu.Unlock()
+	a.catalog = catalog
+}
+
+func (a *App) getCatalog() *config.Catalog {
+	a.catalogMu.RLock()
+	defer a.catalogMu.RUnlock()
+	return a.catalog
+}
+
+// Run serves HTTP and polls the upstream until ctx is cancelled or the server
+// fails. It then stops dispatching new upstream requests, lets in-flight ones
+// and HTTP requests finish within the shutdown timeout, and flushes state.
+func (a *App) Run(ctx context.Context) error {
+	if err := a.restoreSnapshot(); err != nil {
+		slog.Error("Failed to restore cache snapshot", "path", a.snapshotPath, "error", err)
 	}
+
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+
+	server := &http.Server{Addr: a.httpAddress, Handler: a.routes()}
+	server.RegisterOnShutdown(a.events.close)
+	serverErr := make(chan error, 1)
+	go func() {
+		slog.Info("Starting HTTP server", "address", a.httpAddress)
+		serverErr <- server.ListenAndServe()
+	}()
+
+	var background sync.WaitGroup
+	switch {
+	case !a.polling:
+		slog.Info("Polling disabled, serving shared state only")
+	case a.elector != nil:
+		background.Go(func() { leader.Run(ctx, a.elector, a.campaignInterval, a.poll) })
+	default:
+		background.Go(func() { a.poll(ctx) })
+	}
+	background.Go(func() { a.snapshotLoop(ctx) })
+
+	var err error
+	select {
+	case <-ctx.Done():
+		slog.Info("Shutting down", "timeout", a.shutdownTimeout)
+	case err = <-serverErr:
+		slog.Error("HTTP server failed", "error", err)
+		stop()
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), a.shutdownTimeout)
+	defer cancel()
+	if shutdownErr := server.Shutdown(shutdownCtx); shutdownErr != nil {
+		slog.Error("Failed to shut down HTTP server", "error", shutdownErr)
+	}
+	drained := make(chan struct{})
+	go func() {
+		background.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-shutdownCtx.Done():
+		slog.Warn("Timed out waiting for in-flight upstream requests, cancelling them")
+		// Workers still write what they got, so they must finish before Close.
+		a.abortRequests()
+		<-drained
+	}
+
+	if closeErr := a.Close(); closeErr != nil {
+		slog.Error("Failed to close app", "error", closeErr)
+	}
+	slog.Info("Shutdown complete")
+	return err
 }
 
-func (a *App) UpdateOutlets(outlets []string) {
-	a.outlets = outlets
+func (a *App) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/outlets", a.corsMiddleware(a.getOutlets))
+	mux.HandleFunc("/outlets/{id}", a.corsMiddleware(a.getOutlet))
+	mux.HandleFunc("/outlets/{id}/history", a.corsMiddleware(a.getOutletHistory))
+	mux.HandleFunc("/outlets/{id}/energy", a.corsMiddleware(a.getOutletEnergy))
+	mux.HandleFunc("/sessions", a.corsMiddleware(a.getSessions))
+	mux.HandleFunc("/analytics/utilization", a.corsMiddleware(a.getUtilization))
+	mux.HandleFunc("/stations", a.corsMiddleware(a.getStations))
+	mux.HandleFunc("/stations/{id}", a.corsMiddleware(a.getStation))
+	mux.HandleFunc("/providers", a.corsMiddleware(a.getProviders))
+	mux.HandleFunc("/events", a.corsMiddleware(a.getEvents))
+	mux.HandleFunc("/ws", a.corsMiddleware(a.websocketHandler()))
+	mux.HandleFunc("/watches", a.corsMiddleware(a.handleWatches))
+	mux.HandleFunc("/watches/{id}", a.corsMiddleware(a.handleWatch))
+	mux.HandleFunc("/admin/webhooks", a.corsMiddleware(a.adminMiddleware(a.handleWebhooks)))
+	mux.HandleFunc("/admin/webhooks/{id}", a.corsMiddleware(a.adminMiddleware(a.handleWebhook)))
+	return mux
 }
 
-func (a *App) ServeHTTP() {
-	go a.poll()
-	http.HandleFunc("/outlets", a.corsMiddleware(a.getOutlets))
-	slog.Info("Starting HTTP server", "address", a.httpAddress)
-	http.ListenAndServe(a.httpAddress, nil)
+// Close flushes state that must survive a restart and releases the providers
+// and storage.
+func (a *App) Close() error {
+	a.abortRequests()
+	a.notifyMu.Lock()
+	if !a.notifyClosed {
+		a.notifyClosed = true
+		close(a.watchQueue)
+	}
+	a.notifyMu.Unlock()
+	a.notifications.Wait()
+	err := errors.Join(a.webhooks.Close(), a.saveSnapshot())
+	for _, provider := range a.providers {
+		provider.Close()
+	}
+	if a.elector != nil {
+		err = errors.Join(err, a.elector.Close())
+	}
+	return errors.Join(err, a.watches.Close(), a.cache.Close(), a.history.Close(), a.sessionLog.Close())
+}
+
+// outletView is an outlet's cached status annotated with its catalog entry.
+type outletView struct {
+	ID string `json:"id"`
+	cache.OutletInfo
+	StationID   string `json:"station_id,omitempty"`
+	StationName string `json:"station_name,omitempty"`
+	Socket      int    `json:"socket,omitempty"`
+	Label       string `json:"label,omitempty"`
+}
+
+func newOutletView(ref config.OutletRef, info cache.OutletInfo) outletView {
+	view := outletView{
+		ID:         ref.ID,
+		OutletInfo: info,
+		Socket:     ref.Socket,
+		Label:      ref.Label,
+	}
+	if view.State == "" {
+		view.State = cache.StateUnknown
+	}
+	if ref.Station != nil {
+		view.StationID = ref.Station.ID
+		view.StationName = ref.Station.Name
+	}
+	return view
 }
 
 func (a *App) getOutlets(w http.ResponseWriter, r *http.Request) {
+	catalog := a.getCatalog()
+	outlets := make(map[string]outletView)
+	for outletId, info := range a.cache.GetMany(catalog.OutletIDs()) {
+		ref, _ := catalog.Outlet(outletId)
+		outlets[outletId] = newOutletView(ref, info)
+	}
+	writeJSON(w, http.StatusOK, outlets)
+}
+
+// getOutlet answers 404 for outlets missing from the catalog and 202 with the
+// catalog entry alone for outlets that have not been polled yet.
+func (a *App) getOutlet(w http.ResponseWriter, r *http.Request) {
+	ref, ok := a.getCatalog().Outlet(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "outlet not found")
+		return
+	}
+	info, ok := a.cache.Get(ref.ID)
+	if !ok {
+		writeJSON(w, http.StatusAccepted, newOutletView(ref, info))
+		return
+	}
+	writeJSON(w, http.StatusOK, newOutletView(ref, info))
+}
+
+type providerView struct {
+	Name           string               `json:"name"`
+	Type           string               `json:"type"`
+	Capabilities   query.Capabilities   `json:"capabilities"`
+	CircuitBreaker *query.BreakerStatus `json:"circuit_breaker,omitempty"`
+}
+
+func (a *App) getProviders(w http.ResponseWriter, r *http.Request) {
+	providers := make([]providerView, 0, len(a.providers))
+	for _, provider := range a.providers {
+		view := providerView{
+			Name:         provider.Name(),
+			Type:         provider.Type(),
+			Capabilities: provider.Capabilities(),
+		}
+		if breaker, ok := provider.(*query.Breaker); ok {
+			status := breaker.Status()
+			view.CircuitBreaker = &status
+		}
+		providers = append(providers, view)
+	}
+	slices.SortFunc(providers, func(a, b providerView) int { return strings.Compare(a.Name, b.Name) })
+	writeJSON(w, http.StatusOK, providers)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		slog.Error("Failed to encode response", "error", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write(a.cache.JSON())
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
 }
 
 func (a *App) corsMiddleware(handler http.HandlerFunc) http.HandlerFunc {
@@ -55,20 +521,3 @@ func (a *App) corsMiddleware(handler http.HandlerFunc) http.HandlerFunc {
 		handler(w, r)
 	}
 }
-
-func (a *App) poll() {
-	for {
-		errorCount := 0
-		for _, outletId := range a.outlets {
-			power, usedMinutes, err := query.QueryChargeStatus(outletId)
-			if err != nil {
-				slog.Error("Failed to query charge status", "outletId", outletId, "error", err)
-				errorCount++
-				continue
-			}
-			a.cache.Set(outletId, cache.OutletInfo{Power: power, UsedMinutes: usedMinutes})
-			time.Sleep(a.pollingInterval)
-		}
-		slog.Info("Completed a full polling cycle", "errors", errorCount)
-	}
-}
@@ -0,0 +1,255 @@
+package app
+
+import (
+	"charge-monitor/cache"
+	"charge-monitor/config"
+	"charge-monitor/history"
+	"charge-monitor/leader"
+	"charge-monitor/query"
+	"charge-monitor/redis/redistest"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestApp() *App {
+	a, err := NewApp(&config.Config{
+		Stations: []config.Station{{
+			ID:   "xzy-4",
+			Name: "清水河学知苑4号充电桩",
+			Outlets: []config.Outlet{
+				{ID: "outlet-1", Socket: 1, Label: "#1"},
+				{ID: "outlet-7", Socket: 7, Label: "#7"},
+			},
+		}},
+	})
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+func TestGetOutlet(t *testing.T) {
+	a := newTestApp()
+	a.cache.Set("outlet-7", cache.OutletInfo{Power: "88W", UsedMinutes: 12})
+
+	tests := []struct {
+		id         string
+		wantStatus int
+	}{
+		{"outlet-7", http.StatusOK},
+		{"outlet-1", http.StatusAccepted},
+		{"unknown", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/outlets/"+tt.id, nil)
+		req.SetPathValue("id", tt.id)
+		rec := httptest.NewRecorder()
+		a.getOutlet(rec, req)
+		if rec.Code != tt.wantStatus {
+			t.Errorf("GET /outlets/%s: expected status %d, got %d", tt.id, tt.wantStatus, rec.Code)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/outlets/outlet-7", nil)
+	req.SetPathValue("id", "outlet-7")
+	rec := httptest.NewRecorder()
+	a.getOutlet(rec, req)
+	var view map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &view); err != nil {
+		t.Fatalf("Response is not valid JSON: %v", err)
+	}
+	if view["id"] != "outlet-7" || view["power"] != "88W" || view["station_id"] != "xzy-4" || view["label"] != "#7" {
+		t.Errorf("Unexpected response %v", view)
+	}
+}
+
+func TestRun_GracefulShutdown(t *testing.T) {
+	a := newTestApp()
+	a.httpAddress = "127.0.0.1:0"
+	a.snapshotPath = filepath.Join(t.TempDir(), "cache.json")
+	a.providers = map[string]query.Provider{"default": &fakeProvider{delay: 10 * time.Millisecond}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- a.Run(ctx) }()
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Expected clean shutdown, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after cancellation")
+	}
+
+	if _, err := os.Stat(a.snapshotPath); err != nil {
+		t.Errorf("Expected the cache to be flushed on shutdown: %v", err)
+	}
+}
+
+func TestRun_CancelsRequestsAfterShutdownTimeout(t *testing.T) {
+	a := newTestApp()
+	a.httpAddress = "127.0.0.1:0"
+	a.shutdownTimeout = 50 * time.Millisecond
+	provider := &fakeProvider{hang: true}
+	a.providers = map[string]query.Provider{"default": provider}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- a.Run(ctx) }()
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after the shutdown timeout")
+	}
+	if n := provider.inFlight.Load(); n != 0 {
+		t.Errorf("Expected no upstream requests left after Run, got %d", n)
+	}
+	if _, ok := a.cache.Get("outlet-1"); ok {
+		t.Error("Expected a request aborted on shutdown not to count as a failure")
+	}
+}
+
+func TestRun_LeaderElectionFailover(t *testing.T) {
+	lockPath := filepath.Join(t.TempDir(), "leader.lock")
+	newReplica := func() (*App, *fakeProvider) {
+		a := newTestApp()
+		a.httpAddress = "127.0.0.1:0"
+		provider := &fakeProvider{delay: time.Millisecond}
+		a.providers = map[string]query.Provider{"default": provider}
+		a.elector = leader.NewFileLock(lockPath)
+		a.campaignInterval = 5 * time.Millisecond
+		return a, provider
+	}
+	queries := func(p *fakeProvider) int {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		total := 0
+		for _, n := range p.queried {
+			total += n
+		}
+		return total
+	}
+	waitForQueries := func(p *fakeProvider) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for queries(p) == 0 {
+			if time.Now().After(deadline) {
+				t.Fatal("Timed out waiting for the replica to poll")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	first, firstProvider := newReplica()
+	firstCtx, stopFirst := context.WithCancel(context.Background())
+	firstDone := make(chan error, 1)
+	go func() { firstDone <- first.Run(firstCtx) }()
+	waitForQueries(firstProvider)
+
+	second, secondProvider := newReplica()
+	secondCtx, stopSecond := context.WithCancel(context.Background())
+	defer stopSecond()
+	secondDone := make(chan error, 1)
+	go func() { secondDone <- second.Run(secondCtx) }()
+	time.Sleep(30 * time.Millisecond)
+	if n := queries(secondProvider); n != 0 {
+		t.Fatalf("Expected only the leader to poll, the standby made %d queries", n)
+	}
+
+	stopFirst()
+	if err := <-firstDone; err != nil {
+		t.Fatalf("Expected clean shutdown, got %v", err)
+	}
+	waitForQueries(secondProvider)
+	stopSecond()
+	if err := <-secondDone; err != nil {
+		t.Fatalf("Expected clean shutdown, got %v", err)
+	}
+}
+
+func TestNewApp_FileStorage(t *testing.T) {
+	conf := &config.Config{
+		Stations: []config.Station{{ID: "xzy-4", Outlets: []config.Outlet{{ID: "outlet-7"}}}},
+		Storage:  config.StorageConfig{Backend: config.StorageFile, Dir: t.TempDir()},
+		Snapshot: config.SnapshotConfig{Path: "ignored.json"},
+	}
+	a, err := NewApp(conf)
+	if err != nil {
+		t.Fatalf("NewApp failed: %v", err)
+	}
+	if a.snapshotPath != "" {
+		t.Error("Expected snapshots to be disabled with the file backend")
+	}
+	a.cache.Set("outlet-7", cache.OutletInfo{Power: "88W", State: cache.StateCharging})
+	a.history.Append("outlet-7", history.Sample{Time: time.Now().Unix(), Watts: 88})
+	if err := a.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	reopened, err := NewApp(conf)
+	if err != nil {
+		t.Fatalf("Reopening failed: %v", err)
+	}
+	defer reopened.Close()
+	if info, ok := reopened.cache.Get("outlet-7"); !ok || info.Power != "88W" {
+		t.Errorf("Expected the cache to survive a restart, got %+v", info)
+	}
+	if samples := reopened.history.Range("outlet-7", 0, time.Now().Unix()+1); len(samples) != 1 {
+		t.Errorf("Expected the history to survive a restart, got %d samples", len(samples))
+	}
+}
+
+func TestNewApp_RedisStorageIsShared(t *testing.T) {
+	server, err := redistest.NewServer("")
+	if err != nil {
+		t.Fatalf("Failed to start redis server: %v", err)
+	}
+	defer server.Close()
+	conf := &config.Config{
+		Stations: []config.Station{{ID: "xzy-4", Outlets: []config.Outlet{{ID: "outlet-7"}}}},
+		Storage: config.StorageConfig{
+			Backend: config.StorageRedis,
+			Redis:   config.RedisConfig{Address: server.Addr(), TTL: 60000},
+		},
+	}
+	poller, err := NewApp(conf)
+	if err != nil {
+		t.Fatalf("NewApp failed: %v", err)
+	}
+	defer poller.Close()
+	conf.DisablePolling = true
+	replica, err := NewApp(conf)
+	if err != nil {
+		t.Fatalf("NewApp failed: %v", err)
+	}
+	defer replica.Close()
+	if replica.polling || replica.snapshotPath != "" {
+		t.Error("Expected the replica to neither poll nor snapshot")
+	}
+
+	poller.cache.Set("outlet-7", cache.OutletInfo{Power: "88W", State: cache.StateCharging})
+	req := httptest.NewRequest("GET", "/outlets/outlet-7", nil)
+	req.SetPathValue("id", "outlet-7")
+	w := httptest.NewRecorder()
+	replica.getOutlet(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code 200 from the replica, got %d", w.Code)
+	}
+	var view outletView
+	json.Unmarshal(w.Body.Bytes(), &view)
+	if view.Power != "88W" {
+		t.Errorf("Expected the replica to serve the poller's state, got %+v", view)
+	}
+}
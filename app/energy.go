@@ -0,0 +1,131 @@
+package app
+
+import (
+	"charge-monitor/config"
+	"charge-monitor/session"
+	"charge-monitor/tariff"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+	// Tariff time zones must resolve in images without a zoneinfo database.
+	_ "time/tzdata"
+)
+
+func newTariffs(conf map[string]config.TariffConfig) (map[string]*tariff.Tariff, error) {
+	tariffs := make(map[string]*tariff.Tariff)
+	for name, c := range conf {
+		t, err := newTariff(c)
+		if err != nil {
+			return nil, fmt.Errorf("tariff %q: %w", name, err)
+		}
+		tariffs[name] = t
+	}
+	return tariffs, nil
+}
+
+func newTariff(conf config.TariffConfig) (*tariff.Tariff, error) {
+	location := time.Local
+	if conf.TimeZone != "" {
+		var err error
+		if location, err = time.LoadLocation(conf.TimeZone); err != nil {
+			return nil, err
+		}
+	}
+	periods := conf.Periods
+	if len(conf.Tiers) > 0 {
+		if len(periods) > 0 {
+			return nil, errors.New("tiers and periods are mutually exclusive")
+		}
+		periods = []config.TariffPeriodConfig{{Start: "00:00", Tiers: conf.Tiers}}
+	}
+	var parsed []tariff.Period
+	for _, period := range periods {
+		start, err := tariff.ParseTimeOfDay(period.Start)
+		if err != nil {
+			return nil, err
+		}
+		var tiers []tariff.Tier
+		for _, tier := range period.Tiers {
+			tiers = append(tiers, tariff.Tier{MaxWatts: tier.MaxWatts, PerHour: tier.PerHour, PerKWh: tier.PerKWh})
+		}
+		parsed = append(parsed, tariff.Period{Start: start, Tiers: tiers})
+	}
+	return tariff.New(conf.Currency, location, parsed)
+}
+
+// tariffFor returns the tariff of a station, or nil if charging there is not
+// priced.
+func (a *App) tariffFor(stationId string) *tariff.Tariff {
+	name := config.DefaultTariff
+	if station, ok := a.getCatalog().Station(stationId); ok && station.Tariff != "" {
+		name = station.Tariff
+	}
+	return a.tariffs[name]
+}
+
+type energyDay struct {
+	// Date is the day in the time zone of the tariff, as YYYY-MM-DD.
+	Date      string  `json:"date"`
+	EnergyKWh float64 `json:"energy_kwh"`
+	Cost      float64 `json:"cost,omitempty"`
+}
+
+type energyView struct {
+	ID        string      `json:"id"`
+	From      int64       `json:"from"`
+	To        int64       `json:"to"`
+	EnergyKWh float64     `json:"energy_kwh"`
+	Cost      float64     `json:"cost,omitempty"`
+	Currency  string      `json:"currency,omitempty"`
+	Days      []energyDay `json:"days"`
+}
+
+// getOutletEnergy estimates the energy an outlet delivered in [from, to), by
+// default the last 7 days, per day and in total. It integrates the history
+// between consecutive samples of a session the same way sessions do, and
+// credits each stretch to the day it starts in.
+func (a *App) getOutletEnergy(w http.ResponseWriter, r *http.Request) {
+	ref, ok := a.getCatalog().Outlet(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "outlet not found")
+		return
+	}
+	from, to, err := parseRange(r, 7*24*time.Hour)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var stationId string
+	if ref.Station != nil {
+		stationId = ref.Station.ID
+	}
+	t := a.tariffFor(stationId)
+	location := time.Local
+	view := energyView{ID: ref.ID, From: from, To: to, Days: []energyDay{}}
+	if t != nil {
+		location = t.Location()
+		view.Currency = t.Currency
+	}
+
+	samples := a.history.Range(ref.ID, from, to)
+	for i := 1; i < len(samples); i++ {
+		prev, cur := samples[i-1], samples[i]
+		if !session.Active(prev) || !session.Active(cur) || cur.UsedMinutes < prev.UsedMinutes {
+			continue
+		}
+		meter := tariff.Meter{Tariff: t, MaxGap: a.utilizationGap}
+		meter.Add(prev.Time, prev.Watts)
+		meter.Add(cur.Time, cur.Watts)
+		date := time.Unix(prev.Time, 0).In(location).Format(time.DateOnly)
+		if len(view.Days) == 0 || view.Days[len(view.Days)-1].Date != date {
+			view.Days = append(view.Days, energyDay{Date: date})
+		}
+		day := &view.Days[len(view.Days)-1]
+		day.EnergyKWh += meter.EnergyKWh()
+		day.Cost += meter.Cost
+		view.EnergyKWh += meter.EnergyKWh()
+		view.Cost += meter.Cost
+	}
+	writeJSON(w, http.StatusOK, view)
+}
@@ -0,0 +1,94 @@
+package app
+
+import (
+	"charge-monitor/cache"
+	"charge-monitor/config"
+	"charge-monitor/history"
+	"encoding/json"
+	"math"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestGetOutletEnergy(t *testing.T) {
+	a := newTestApp()
+	tariffs, err := newTariffs(map[string]config.TariffConfig{
+		config.DefaultTariff: {Currency: "CNY", TimeZone: "Asia/Shanghai", Tiers: []config.TariffTierConfig{{MaxWatts: 200, PerHour: 0.25}, {PerHour: 0.5}}},
+	})
+	if err != nil {
+		t.Fatalf("newTariffs failed: %v", err)
+	}
+	a.tariffs = tariffs
+	// Samples an hour apart still count in full.
+	a.utilizationGap = 2 * time.Hour
+
+	// 2024-01-01T23:00+08:00: an hour at 100W, then an hour at 400W after
+	// midnight, idle, and a new vehicle plugged in.
+	start := int64(1704121200)
+	samples := []history.Sample{
+		{Time: start, Watts: 100, UsedMinutes: 1, State: cache.StateCharging},
+		{Time: start + 3600, Watts: 100, UsedMinutes: 61, State: cache.StateCharging},
+		{Time: start + 3601, Watts: 400, UsedMinutes: 61, State: cache.StateCharging},
+		{Time: start + 7201, Watts: 400, UsedMinutes: 121, State: cache.StateCharging},
+		{Time: start + 7800, State: cache.StateIdle},
+		{Time: start + 8400, Watts: 400, UsedMinutes: 1, State: cache.StateCharging},
+	}
+	for _, sample := range samples {
+		a.history.Append("outlet-7", sample)
+	}
+
+	rec := doRequest(t, a.routes(), http.MethodGet, "/outlets/outlet-7/energy?from=1704000000&to=1704200000", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var view energyView
+	if err := json.Unmarshal(rec.Body.Bytes(), &view); err != nil {
+		t.Fatalf("Response is not valid JSON: %v", err)
+	}
+	if len(view.Days) != 2 || view.Days[0].Date != "2024-01-01" || view.Days[1].Date != "2024-01-02" {
+		t.Fatalf("Expected two local days, got %+v", view.Days)
+	}
+	if math.Abs(view.Days[0].EnergyKWh-0.1) > 1e-9 || math.Abs(view.Days[0].Cost-0.25) > 1e-9 {
+		t.Errorf("Unexpected first day %+v", view.Days[0])
+	}
+	// The second day includes the second at 250W between the two readings.
+	if math.Abs(view.EnergyKWh-(0.1+0.4+250.0/3.6e6)) > 1e-9 || view.Currency != "CNY" {
+		t.Errorf("Unexpected total %v %s", view.EnergyKWh, view.Currency)
+	}
+
+	if rec := doRequest(t, a.routes(), http.MethodGet, "/outlets/unknown/energy", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown outlet, got %d", rec.Code)
+	}
+}
+
+func TestGetOutletEnergy_CapsGaps(t *testing.T) {
+	a := newTestApp()
+	// Polling stopped for an hour in the middle of a session at 1000W.
+	start := int64(1704121200)
+	a.history.Append("outlet-7", history.Sample{Time: start, Watts: 1000, UsedMinutes: 1, State: cache.StateCharging})
+	a.history.Append("outlet-7", history.Sample{Time: start + 3600, Watts: 1000, UsedMinutes: 61, State: cache.StateCharging})
+
+	rec := doRequest(t, a.routes(), http.MethodGet, "/outlets/outlet-7/energy?from=1704000000&to=1704200000", "")
+	var view energyView
+	if err := json.Unmarshal(rec.Body.Bytes(), &view); err != nil {
+		t.Fatalf("Response is not valid JSON: %v", err)
+	}
+	// Only the default 15 minutes after the first sample count.
+	if math.Abs(view.EnergyKWh-0.25) > 1e-9 {
+		t.Errorf("Expected 0.25 kWh, got %v", view.EnergyKWh)
+	}
+}
+
+func TestNewTariffs_Invalid(t *testing.T) {
+	tests := map[string]config.TariffConfig{
+		"unknown time zone": {TimeZone: "Mars/Olympus", Tiers: []config.TariffTierConfig{{PerHour: 1}}},
+		"bad start":         {Periods: []config.TariffPeriodConfig{{Start: "25:00", Tiers: []config.TariffTierConfig{{PerHour: 1}}}}},
+		"tiers and periods": {Tiers: []config.TariffTierConfig{{}}, Periods: []config.TariffPeriodConfig{{Start: "00:00", Tiers: []config.TariffTierConfig{{}}}}},
+	}
+	for name, conf := range tests {
+		if _, err := newTariffs(map[string]config.TariffConfig{"test": conf}); err == nil {
+			t.Errorf("Expected %s to be rejected", name)
+		}
+	}
+}
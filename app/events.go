@@ -0,0 +1,231 @@
+package app
+
+import (
+	"charge-monitor/cache"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// change is an outlet whose state or power changed, as pushed to clients.
+type change struct {
+	outletView
+	PreviousState cache.State       `json:"previous_state,omitempty"`
+	Events        []cache.EventType `json:"events"`
+}
+
+type event struct {
+	id     uint64
+	change change
+}
+
+// broker fans changes out to stream subscribers and keeps the most recent
+// ones so that a reconnecting client can resume where it left off.
+type broker struct {
+	// epoch tells the IDs of this process apart from those of an earlier run
+	// or another replica, which count from 1 too.
+	epoch  string
+	mu     sync.Mutex
+	lastID uint64
+	// recent is a ring buffer of the last len(recent) events.
+	recent      []event
+	subscribers map[chan event]struct{}
+	closed      bool
+}
+
+func newBroker(size int) *broker {
+	return &broker{
+		epoch:       strconv.FormatInt(time.Now().UnixNano(), 36),
+		recent:      make([]event, 0, size),
+		subscribers: make(map[chan event]struct{}),
+	}
+}
+
+// eventID is the ID clients see for e.
+func (b *broker) eventID(e event) string {
+	return b.epoch + "-" + strconv.FormatUint(e.id, 10)
+}
+
+func (b *broker) publish(c change) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lastID++
+	e := event{id: b.lastID, change: c}
+	if len(b.recent) < cap(b.recent) {
+		b.recent = append(b.recent, e)
+	} else {
+		b.recent[int(e.id-1)%cap(b.recent)] = e
+	}
+	for ch := range b.subscribers {
+		select {
+		case ch <- e:
+		default:
+			// A subscriber this far behind is dropped; it can reconnect
+			// with Last-Event-ID and resume from the buffer.
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// subscribe returns a channel of the events to come, which is closed when the
+// subscriber falls behind or the broker closes. Given the ID of the last event
+// a client saw, it also returns the buffered events after it, or ok false if
+// some were discarded or the ID is not from this broker.
+func (b *broker) subscribe(lastEventID string) (backlog []event, ch chan event, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ch = make(chan event, 64)
+	if b.closed {
+		close(ch)
+		return nil, ch, true
+	}
+	b.subscribers[ch] = struct{}{}
+	if lastEventID == "" {
+		return nil, ch, true
+	}
+	epoch, seq, _ := strings.Cut(lastEventID, "-")
+	lastID, err := strconv.ParseUint(seq, 10, 64)
+	if epoch != b.epoch || err != nil || lastID > b.lastID {
+		// From before a restart, or another replica.
+		return nil, ch, false
+	}
+	oldest := b.lastID - uint64(len(b.recent)) + 1
+	if lastID+1 < oldest {
+		return nil, ch, false
+	}
+	for id := lastID + 1; id <= b.lastID; id++ {
+		backlog = append(backlog, b.recent[int(id-1)%cap(b.recent)])
+	}
+	return backlog, ch, true
+}
+
+func (b *broker) unsubscribe(ch chan event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subscribers[ch]; ok {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+// close ends every stream, since the HTTP server waits for them on shutdown.
+func (b *broker) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for ch := range b.subscribers {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+// publishChange forwards state and power changes from the cache to the
+// event streams.
+func (a *App) publishChange(c cache.Change) {
+	if !c.Has(cache.EventStateChanged) && !c.Has(cache.EventPowerChanged) {
+		return
+	}
+	ref, _ := a.getCatalog().Outlet(c.OutletID)
+	a.events.publish(change{outletView: newOutletView(ref, c.Current), PreviousState: c.Previous.State, Events: c.Events})
+}
+
+// eventFilter selects changes by outlet or station ID; empty matches all.
+type eventFilter struct {
+	outlets  map[string]bool
+	stations map[string]bool
+}
+
+// newEventFilter reads the outlet and station query parameters, each of which
+// may be repeated or hold a comma-separated list.
+func newEventFilter(query url.Values) eventFilter {
+	return eventFilter{outlets: idSet(query["outlet"]), stations: idSet(query["station"])}
+}
+
+func idSet(values []string) map[string]bool {
+	set := make(map[string]bool)
+	for _, value := range values {
+		for id := range strings.SplitSeq(value, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				set[id] = true
+			}
+		}
+	}
+	return set
+}
+
+func (f eventFilter) match(c change) bool {
+	return f.matchOutlet(c.ID, c.StationID)
+}
+
+func (f eventFilter) matchOutlet(outletId, stationId string) bool {
+	if len(f.outlets) == 0 && len(f.stations) == 0 {
+		return true
+	}
+	return f.outlets[outletId] || (stationId != "" && f.stations[stationId])
+}
+
+// heartbeatInterval keeps idle streams from being cut by proxies.
+const heartbeatInterval = 15 * time.Second
+
+// getEvents streams outlet changes as Server-Sent Events. A client resuming
+// with Last-Event-ID gets the changes it missed, or a reset event telling it
+// to refetch /outlets when they are no longer buffered.
+func (a *App) getEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming unsupported")
+		return
+	}
+	filter := newEventFilter(r.URL.Query())
+	backlog, ch, ok := a.events.subscribe(r.Header.Get("Last-Event-ID"))
+	defer a.events.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 3000\n\n")
+	if !ok {
+		fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+	}
+	for _, e := range backlog {
+		a.writeEvent(w, e, filter)
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case e, open := <-ch:
+			if !open {
+				return
+			}
+			a.writeEvent(w, e, filter)
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func (a *App) writeEvent(w http.ResponseWriter, e event, filter eventFilter) {
+	if !filter.match(e.change) {
+		return
+	}
+	data, err := json.Marshal(e.change)
+	if err != nil {
+		slog.Error("Failed to encode event", "error", err)
+		return
+	}
+	fmt.Fprintf(w, "id: %s\nevent: outlet\ndata: %s\n\n", a.events.eventID(e), data)
+}
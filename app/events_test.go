@@ -0,0 +1,155 @@
+package app
+
+import (
+	"bufio"
+	"charge-monitor/cache"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestBroker_Resume(t *testing.T) {
+	b := newBroker(2)
+	for range 3 {
+		b.publish(change{})
+	}
+
+	backlog, _, ok := b.subscribe(b.epoch + "-1")
+	if !ok || len(backlog) != 2 || backlog[0].id != 2 || backlog[1].id != 3 {
+		t.Errorf("Expected events 2 and 3 after 1, got %+v (ok=%v)", backlog, ok)
+	}
+	if _, _, ok := b.subscribe(b.epoch + "-0"); ok {
+		t.Error("Expected resuming past the buffer to fail")
+	}
+	if _, _, ok := b.subscribe(b.epoch + "-99"); ok {
+		t.Error("Expected resuming from an unknown ID to fail")
+	}
+	// After a restart, IDs count from 1 again under a new epoch.
+	if _, _, ok := b.subscribe("earlier-2"); ok {
+		t.Error("Expected resuming from another epoch to fail")
+	}
+	if _, _, ok := b.subscribe("2"); ok {
+		t.Error("Expected resuming from an ID without epoch to fail")
+	}
+	if backlog, _, ok := b.subscribe(""); !ok || len(backlog) != 0 {
+		t.Errorf("Expected a new subscriber to get no backlog, got %d events", len(backlog))
+	}
+}
+
+func TestBroker_DropsSlowSubscriber(t *testing.T) {
+	b := newBroker(16)
+	_, ch, _ := b.subscribe("")
+	for range cap(ch) + 1 {
+		b.publish(change{})
+	}
+	received := 0
+	for range ch {
+		received++
+	}
+	if received != cap(ch) {
+		t.Errorf("Expected %d buffered events before the channel closed, got %d", cap(ch), received)
+	}
+}
+
+func TestPublishChange_OnlyStateOrPower(t *testing.T) {
+	a := newTestApp()
+	_, ch, _ := a.events.subscribe("")
+
+	a.cache.Set("outlet-7", cache.OutletInfo{Power: "0W", State: cache.StateIdle})
+	a.cache.Set("outlet-7", cache.OutletInfo{Power: "0W", State: cache.StateIdle, ConsecutiveFailures: 1})
+	a.cache.Set("outlet-7", cache.OutletInfo{Power: "88W", Watts: 88, State: cache.StateCharging})
+
+	if len(ch) != 2 {
+		t.Fatalf("Expected 2 events, got %d", len(ch))
+	}
+	<-ch
+	e := <-ch
+	if e.change.ID != "outlet-7" || e.change.StationID != "xzy-4" || e.change.State != cache.StateCharging || e.change.PreviousState != cache.StateIdle {
+		t.Errorf("Unexpected change %+v", e.change)
+	}
+	if !slices.Contains(e.change.Events, cache.EventBecameBusy) {
+		t.Errorf("Expected the outlet to become busy, got %v", e.change.Events)
+	}
+}
+
+// readEvent returns the id and data of the next event on the stream,
+// skipping comments and fields it does not need.
+func readEvent(t *testing.T, r *bufio.Reader) (id, name string, data change) {
+	t.Helper()
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read event: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && name != "":
+			return id, name, data
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &data); err != nil {
+				t.Fatalf("Event data is not valid JSON: %v", err)
+			}
+		}
+	}
+}
+
+func openEvents(t *testing.T, server *httptest.Server, path, lastEventID string) *bufio.Reader {
+	t.Helper()
+	req, _ := http.NewRequest("GET", server.URL+path, nil)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Expected an event stream, got %q", ct)
+	}
+	r := bufio.NewReader(resp.Body)
+	// The retry field is flushed once the stream is subscribed.
+	if line, _ := r.ReadString('\n'); line != "retry: 3000\n" {
+		t.Fatalf("Unexpected first line %q", line)
+	}
+	return r
+}
+
+func TestGetEvents_FilterAndResume(t *testing.T) {
+	a := newTestApp()
+	server := httptest.NewServer(a.routes())
+	defer server.Close()
+	defer a.events.close()
+
+	r := openEvents(t, server, "/events?outlet=outlet-7", "")
+	a.cache.Set("outlet-1", cache.OutletInfo{Power: "10W", State: cache.StateCharging})
+	a.cache.Set("outlet-7", cache.OutletInfo{Power: "0W", State: cache.StateIdle})
+	epoch := a.events.epoch
+	id, name, data := readEvent(t, r)
+	if id != epoch+"-2" || name != "outlet" || data.ID != "outlet-7" || data.State != cache.StateIdle {
+		t.Errorf("Expected only the change of outlet-7, got id %s: %+v", id, data)
+	}
+
+	r = openEvents(t, server, "/events?station=xzy-4", epoch+"-1")
+	if id, _, data := readEvent(t, r); id != epoch+"-2" || data.ID != "outlet-7" {
+		t.Errorf("Expected to resume with event 2, got id %s: %+v", id, data)
+	}
+
+	r = openEvents(t, server, "/events", epoch+"-42")
+	if _, name, _ := readEvent(t, r); name != "reset" {
+		t.Errorf("Expected a reset event for an unknown ID, got %q", name)
+	}
+
+	// So does an ID from before a restart, even one this run has reached.
+	r = openEvents(t, server, "/events", "earlier-1")
+	if _, name, _ := readEvent(t, r); name != "reset" {
+		t.Errorf("Expected a reset event for an ID of another run, got %q", name)
+	}
+}
@@ -0,0 +1,77 @@
+package app
+
+import (
+	"charge-monitor/history"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type historyView struct {
+	ID      string           `json:"id"`
+	From    int64            `json:"from"`
+	To      int64            `json:"to"`
+	Step    int64            `json:"step"`
+	Samples []history.Sample `json:"samples"`
+}
+
+// getOutletHistory returns the samples of an outlet in [from, to), by default
+// the last 24 hours, downsampled to step seconds when step is set.
+func (a *App) getOutletHistory(w http.ResponseWriter, r *http.Request) {
+	ref, ok := a.getCatalog().Outlet(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "outlet not found")
+		return
+	}
+	query := r.URL.Query()
+	from, to, err := parseRange(r, 24*time.Hour)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var step int64
+	if value := query.Get("step"); value != "" {
+		step, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || step < 0 {
+			writeError(w, http.StatusBadRequest, "invalid step: must be a non-negative number of seconds")
+			return
+		}
+	}
+	samples := history.Downsample(a.history.Range(ref.ID, from, to), step)
+	if samples == nil {
+		samples = []history.Sample{}
+	}
+	writeJSON(w, http.StatusOK, historyView{ID: ref.ID, From: from, To: to, Step: step, Samples: samples})
+}
+
+// parseRange reads the from and to query parameters of r. to defaults to now
+// and from to span before to.
+func parseRange(r *http.Request, span time.Duration) (from, to int64, err error) {
+	query := r.URL.Query()
+	to, err = parseTime(query.Get("to"), time.Now().Unix()+1)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid to: %w", err)
+	}
+	from, err = parseTime(query.Get("from"), to-int64(span/time.Second))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid from: %w", err)
+	}
+	return from, to, nil
+}
+
+// parseTime accepts a Unix timestamp in seconds or an RFC 3339 time, and
+// returns def for an empty value.
+func parseTime(value string, def int64) (int64, error) {
+	if value == "" {
+		return def, nil
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return seconds, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return 0, fmt.Errorf("expected Unix seconds or RFC 3339 time, got %q", value)
+	}
+	return t.Unix(), nil
+}
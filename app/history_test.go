@@ -0,0 +1,45 @@
+package app
+
+import (
+	"charge-monitor/cache"
+	"charge-monitor/history"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGetOutletHistory(t *testing.T) {
+	a := newTestApp()
+	for i := range 6 {
+		a.history.Append("outlet-7", history.Sample{Time: int64(1000 + i*60), Watts: float64(80 + i*4), State: cache.StateCharging})
+	}
+
+	get := func(target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.SetPathValue("id", "outlet-7")
+		rec := httptest.NewRecorder()
+		a.getOutletHistory(rec, req)
+		return rec
+	}
+
+	rec := get("/outlets/outlet-7/history?from=1000&to=1300&step=120")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var view historyView
+	if err := json.Unmarshal(rec.Body.Bytes(), &view); err != nil {
+		t.Fatalf("Response is not valid JSON: %v", err)
+	}
+	// Samples at 1000..1240 fall into buckets starting at 960, 1080 and 1200.
+	if len(view.Samples) != 3 || view.Samples[0].Time != 960 {
+		t.Errorf("Unexpected downsampled history %+v", view.Samples)
+	}
+
+	if rec := get("/outlets/outlet-7/history?from=1970-01-01T00:16:40Z&to=1060"); rec.Code != http.StatusOK {
+		t.Errorf("Expected RFC 3339 times to be accepted, got %d", rec.Code)
+	}
+	if rec := get("/outlets/outlet-7/history?step=-1"); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected a negative step to be rejected, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,218 @@
+package app
+
+import (
+	"charge-monitor/cache"
+	"charge-monitor/config"
+	"charge-monitor/history"
+	"charge-monitor/query"
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// rateLimitInterval is the minimum gap between two upstream requests across
+// all workers. polling_rate_limit takes precedence over polling_interval.
+func rateLimitInterval(conf *config.Config) time.Duration {
+	if conf.PollingRateLimit > 0 {
+		return time.Duration(float64(time.Second) / conf.PollingRateLimit)
+	}
+	return time.Duration(conf.PollingInterval) * time.Millisecond
+}
+
+// poll runs polling cycles until ctx is cancelled. Cancellation stops new
+// requests from being dispatched; requests already in flight run to
+// completion so their results are not lost.
+func (a *App) poll(ctx context.Context) {
+	var limiter <-chan time.Time
+	if a.requestInterval > 0 {
+		ticker := time.NewTicker(a.requestInterval)
+		defer ticker.Stop()
+		limiter = ticker.C
+	}
+	for ctx.Err() == nil {
+		start := time.Now()
+		outletIds := a.getCatalog().OutletIDs()
+		errorCount := a.pollCycle(ctx, outletIds, limiter)
+		slog.Info("Completed a full polling cycle", "outlets", len(outletIds), "errors", errorCount, "duration", time.Since(start))
+		if len(outletIds) == 0 {
+			sleep(ctx, time.Second)
+		}
+	}
+}
+
+// pollCycle queries every outlet once using a pool of a.concurrency workers.
+// Each dispatch waits for a tick from limiter, if set, so the request rate
+// stays bounded regardless of concurrency. It returns the number of failures.
+func (a *App) pollCycle(ctx context.Context, outletIds []string, limiter <-chan time.Time) int {
+	var errorCount atomic.Int64
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	for range a.concurrency {
+		wg.Go(func() {
+			for outletId := range jobs {
+				if !a.pollOutlet(ctx, outletId, limiter) {
+					errorCount.Add(1)
+				}
+			}
+		})
+	}
+dispatch:
+	for _, outletId := range outletIds {
+		if !wait(ctx, limiter) {
+			break
+		}
+		select {
+		case jobs <- outletId:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return int(errorCount.Load())
+}
+
+// wait blocks for a tick from limiter, if set, and reports false if ctx is
+// cancelled first.
+func wait(ctx context.Context, limiter <-chan time.Time) bool {
+	if limiter == nil {
+		return ctx.Err() == nil
+	}
+	select {
+	case <-limiter:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// sleep pauses for d and reports false if ctx is cancelled first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+type retryPolicy struct {
+	// attempts is the number of retries after the first failed query.
+	attempts  int
+	baseDelay time.Duration
+	maxDelay  time.Duration
+}
+
+func newRetryPolicy(conf config.RetryConfig) retryPolicy {
+	policy := retryPolicy{
+		attempts:  max(conf.Attempts, 0),
+		baseDelay: time.Duration(conf.BaseDelay) * time.Millisecond,
+		maxDelay:  time.Duration(conf.MaxDelay) * time.Millisecond,
+	}
+	if policy.baseDelay <= 0 {
+		policy.baseDelay = 200 * time.Millisecond
+	}
+	if policy.maxDelay < policy.baseDelay {
+		policy.maxDelay = max(5*time.Second, policy.baseDelay)
+	}
+	return policy
+}
+
+// backoff returns the delay before the given retry (0-based): the base delay
+// doubled per attempt and capped at the max delay, jittered into its upper half
+// so that workers failing together do not retry in lockstep.
+func (p retryPolicy) backoff(attempt int) time.Duration {
+	delay := p.maxDelay
+	if attempt < 32 {
+		delay = min(p.baseDelay<<attempt, p.maxDelay)
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// pollOutlet queries one outlet, retrying failures, and updates the cache.
+// Retries also wait for limiter so they count towards the request rate.
+// Cancelling ctx stops further retries but not the request in flight, which
+// is only cancelled through a.requests.
+func (a *App) pollOutlet(ctx context.Context, outletId string, limiter <-chan time.Time) bool {
+	ref, _ := a.getCatalog().Outlet(outletId)
+	provider, ok := a.providers[ref.Provider()]
+	if !ok {
+		// Providers are only built at startup, so a reload may refer to a new one.
+		slog.Error("No provider for outlet", "outletId", outletId, "provider", ref.Provider())
+		return false
+	}
+	var info cache.OutletInfo
+	var err error
+	var codeErr *query.ResponseCodeError
+	for attempt := 0; ; attempt++ {
+		info, err = provider.QueryChargeStatus(a.requests, outletId)
+		// A response code error is the upstream's answer, asking again won't change it.
+		if err == nil || attempt >= a.retry.attempts || errors.As(err, &codeErr) || errors.Is(err, query.ErrCircuitOpen) {
+			break
+		}
+		if !sleep(ctx, a.retry.backoff(attempt)) || !wait(ctx, limiter) {
+			break
+		}
+	}
+	if a.requests.Err() != nil {
+		// Aborted on shutdown, which is not the outlet's fault.
+		return false
+	}
+	if errors.Is(err, query.ErrCircuitOpen) {
+		// The breaker logs its own transitions, and the outlet itself is not at fault.
+		return false
+	}
+	if err != nil {
+		slog.Error("Failed to query charge status", "outletId", outletId, "provider", provider.Name(), "error", err)
+		a.recordFailure(outletId, err)
+		return false
+	}
+	info.LastSuccessAt = time.Now().Unix()
+	a.cache.Set(outletId, info)
+	return true
+}
+
+// recordFailure keeps an outlet's last known values but tracks the failure.
+// The outlet is flagged offline once it keeps failing, or immediately when
+// the upstream itself reports it as unusable; its last power is then cleared,
+// as nothing says it is still drawn.
+func (a *App) recordFailure(outletId string, err error) {
+	info, _ := a.cache.Get(outletId)
+	info.ConsecutiveFailures++
+	info.LastError = err.Error()
+	var codeErr *query.ResponseCodeError
+	if errors.As(err, &codeErr) || info.ConsecutiveFailures >= a.offlineAfter {
+		info.State = cache.StateOffline
+		info.Power = ""
+		info.Watts = 0
+	}
+	a.cache.Set(outletId, info)
+}
+
+// recordSample samples every successful query, the only Set that leaves no
+// consecutive failures, and the moment an outlet goes offline, into the
+// history and the session tracker. LastSuccessAt has a resolution of one
+// second, so it does not tell two queries in the same second apart.
+func (a *App) recordSample(c cache.Change) {
+	var sample history.Sample
+	switch {
+	case c.Current.ConsecutiveFailures == 0:
+		sample = history.SampleOf(c.Current, time.Unix(c.Current.LastSuccessAt, 0))
+	case c.Has(cache.EventStateChanged) && c.Current.State == cache.StateOffline:
+		sample = history.SampleOf(cache.OutletInfo{State: cache.StateOffline}, time.Now())
+	default:
+		return
+	}
+	a.history.Append(c.OutletID, sample)
+	var stationId string
+	if ref, ok := a.getCatalog().Outlet(c.OutletID); ok && ref.Station != nil {
+		stationId = ref.Station.ID
+	}
+	a.sessions.Observe(c.OutletID, stationId, sample)
+}
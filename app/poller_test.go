@@ -0,0 +1,250 @@
+package app
+
+import (
+	"charge-monitor/cache"
+	"charge-monitor/query"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type fakeProvider struct {
+	delay time.Duration
+	// failFirst makes the first failFirst queries of each outlet fail with err.
+	failFirst int
+	err       error
+	// hang makes queries block until their context is cancelled.
+	hang bool
+
+	inFlight atomic.Int64
+	peak     atomic.Int64
+	mu       sync.Mutex
+	queried  map[string]int
+}
+
+func (p *fakeProvider) Name() string { return "fake" }
+
+func (p *fakeProvider) Type() string { return "fake" }
+
+func (p *fakeProvider) Capabilities() query.Capabilities { return query.Capabilities{Power: true} }
+
+func (p *fakeProvider) QueryChargeStatus(ctx context.Context, outletId string) (cache.OutletInfo, error) {
+	n := p.inFlight.Add(1)
+	defer p.inFlight.Add(-1)
+	for {
+		peak := p.peak.Load()
+		if n <= peak || p.peak.CompareAndSwap(peak, n) {
+			break
+		}
+	}
+	time.Sleep(p.delay)
+	if p.hang {
+		<-ctx.Done()
+		return cache.OutletInfo{}, ctx.Err()
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.queried == nil {
+		p.queried = make(map[string]int)
+	}
+	p.queried[outletId]++
+	if p.queried[outletId] <= p.failFirst {
+		return cache.OutletInfo{}, p.err
+	}
+	return cache.OutletInfo{Power: "88W", Watts: 88, State: cache.StateCharging}, nil
+}
+
+func (p *fakeProvider) Close() error { return nil }
+
+func TestPollCycle_Concurrency(t *testing.T) {
+	a := newTestApp()
+	provider := &fakeProvider{delay: 20 * time.Millisecond}
+	a.providers = map[string]query.Provider{"default": provider}
+	a.concurrency = 4
+
+	var outletIds []string
+	for i := range 20 {
+		outletIds = append(outletIds, fmt.Sprintf("outlet-%d", i))
+	}
+
+	start := time.Now()
+	if errorCount := a.pollCycle(context.Background(), outletIds, nil); errorCount != 0 {
+		t.Errorf("Expected no errors, got %d", errorCount)
+	}
+	elapsed := time.Since(start)
+
+	for _, outletId := range outletIds {
+		if provider.queried[outletId] != 1 {
+			t.Errorf("Expected %s to be queried once, got %d", outletId, provider.queried[outletId])
+		}
+		if _, ok := a.cache.Get(outletId); !ok {
+			t.Errorf("Expected %s to be cached", outletId)
+		}
+	}
+	if peak := provider.peak.Load(); peak > 4 || peak < 2 {
+		t.Errorf("Expected between 2 and 4 concurrent requests, got %d", peak)
+	}
+	// 20 outlets at 20ms each take 400ms sequentially.
+	if elapsed > 300*time.Millisecond {
+		t.Errorf("Expected the cycle to benefit from concurrency, took %v", elapsed)
+	}
+}
+
+func TestPollCycle_RateLimit(t *testing.T) {
+	a := newTestApp()
+	a.providers = map[string]query.Provider{"default": &fakeProvider{}}
+	a.concurrency = 8
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	start := time.Now()
+	a.pollCycle(context.Background(), []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"}, ticker.C)
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("Expected 10 requests at 100/s to take at least 90ms, took %v", elapsed)
+	}
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	policy := retryPolicy{attempts: 5, baseDelay: 100 * time.Millisecond, maxDelay: time.Second}
+	for attempt, ceiling := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		ceiling *= time.Millisecond
+		for range 20 {
+			delay := policy.backoff(attempt)
+			if delay < ceiling/2 || delay > ceiling {
+				t.Fatalf("Attempt %d: expected delay in [%v, %v], got %v", attempt, ceiling/2, ceiling, delay)
+			}
+		}
+	}
+	if delay := policy.backoff(100); delay > time.Second {
+		t.Errorf("Expected large attempts to be capped, got %v", delay)
+	}
+}
+
+func TestPollOutlet_RetriesThenSucceeds(t *testing.T) {
+	a := newTestApp()
+	provider := &fakeProvider{failFirst: 2, err: errors.New("connection reset")}
+	a.providers = map[string]query.Provider{"default": provider}
+	a.retry = retryPolicy{attempts: 2, baseDelay: time.Millisecond, maxDelay: time.Millisecond}
+
+	if !a.pollOutlet(context.Background(), "outlet-1", nil) {
+		t.Fatal("Expected the query to succeed after retries")
+	}
+	if provider.queried["outlet-1"] != 3 {
+		t.Errorf("Expected 3 attempts, got %d", provider.queried["outlet-1"])
+	}
+	info, _ := a.cache.Get("outlet-1")
+	if info.LastSuccessAt == 0 || info.ConsecutiveFailures != 0 || info.LastError != "" {
+		t.Errorf("Expected a clean successful entry, got %+v", info)
+	}
+}
+
+func TestPollOutlet_TracksFailures(t *testing.T) {
+	a := newTestApp()
+	a.providers = map[string]query.Provider{"default": &fakeProvider{}}
+	a.retry = retryPolicy{attempts: 1, baseDelay: time.Millisecond, maxDelay: time.Millisecond}
+	a.offlineAfter = 2
+	a.pollOutlet(context.Background(), "outlet-1", nil)
+
+	a.providers = map[string]query.Provider{"default": &fakeProvider{failFirst: 100, err: errors.New("timeout")}}
+	a.pollOutlet(context.Background(), "outlet-1", nil)
+	info, _ := a.cache.Get("outlet-1")
+	if info.ConsecutiveFailures != 1 || info.LastError != "timeout" {
+		t.Errorf("Expected 1 failure with last error timeout, got %+v", info)
+	}
+	if info.State != cache.StateCharging || info.Power != "88W" {
+		t.Errorf("Expected last known values to be kept after one failure, got %+v", info)
+	}
+
+	a.pollOutlet(context.Background(), "outlet-1", nil)
+	info, _ = a.cache.Get("outlet-1")
+	if info.ConsecutiveFailures != 2 || info.State != cache.StateOffline {
+		t.Errorf("Expected outlet to be offline after 2 failures, got %+v", info)
+	}
+	if info.Power != "" || info.Watts != 0 {
+		t.Errorf("Expected the power of an offline outlet to be cleared, got %+v", info)
+	}
+	if station := summarizeStation(&a.getCatalog().Stations[0], a.cache.GetMany([]string{"outlet-1"})); station.Offline != 1 || station.PowerWatts != 0 {
+		t.Errorf("Expected an offline outlet to add no power to its station, got %+v", station.stationSummary)
+	}
+	if info.LastSuccessAt == 0 {
+		t.Error("Expected LastSuccessAt to keep the time of the last success")
+	}
+}
+
+func TestPollOutlet_SamplesEverySuccess(t *testing.T) {
+	a := newTestApp()
+	a.providers = map[string]query.Provider{"default": &fakeProvider{}}
+	a.retry = retryPolicy{attempts: 0}
+	a.offlineAfter = 2
+	// Both successes most likely fall within the same second.
+	a.pollOutlet(context.Background(), "outlet-1", nil)
+	a.pollOutlet(context.Background(), "outlet-1", nil)
+	a.providers = map[string]query.Provider{"default": &fakeProvider{failFirst: 100, err: errors.New("timeout")}}
+	a.pollOutlet(context.Background(), "outlet-1", nil)
+
+	if samples := a.history.Range("outlet-1", 0, time.Now().Unix()+1); len(samples) != 2 {
+		t.Errorf("Expected a sample for each success and none for the failure, got %+v", samples)
+	}
+}
+
+func TestPollOutlet_ResponseCodeNotRetried(t *testing.T) {
+	a := newTestApp()
+	provider := &fakeProvider{failFirst: 100, err: &query.ResponseCodeError{Code: "0"}}
+	a.providers = map[string]query.Provider{"default": provider}
+	a.retry = retryPolicy{attempts: 3, baseDelay: time.Millisecond, maxDelay: time.Millisecond}
+
+	a.pollOutlet(context.Background(), "outlet-1", nil)
+	if provider.queried["outlet-1"] != 1 {
+		t.Errorf("Expected a single attempt, got %d", provider.queried["outlet-1"])
+	}
+	if info, _ := a.cache.Get("outlet-1"); info.State != cache.StateOffline {
+		t.Errorf("Expected outlet to be offline immediately, got %s", info.State)
+	}
+}
+
+func TestPollOutlet_CircuitOpen(t *testing.T) {
+	a := newTestApp()
+	provider := &fakeProvider{failFirst: 100, err: errors.New("connection refused")}
+	breaker := query.NewBreaker(provider, 1, time.Hour)
+	a.providers = map[string]query.Provider{"default": breaker}
+	a.retry = retryPolicy{attempts: 3, baseDelay: time.Millisecond, maxDelay: time.Millisecond}
+
+	a.pollOutlet(context.Background(), "outlet-1", nil)
+	if provider.queried["outlet-1"] != 1 {
+		t.Errorf("Expected retries to stop once the breaker opened, got %d attempts", provider.queried["outlet-1"])
+	}
+
+	a.pollOutlet(context.Background(), "outlet-7", nil)
+	if provider.queried["outlet-7"] != 0 {
+		t.Errorf("Expected no upstream request while the breaker is open, got %d", provider.queried["outlet-7"])
+	}
+	if info, ok := a.cache.Get("outlet-7"); ok {
+		t.Errorf("Expected rejected queries not to count against the outlet, got %+v", info)
+	}
+}
+
+func TestPollCycle_StopsDispatchingOnCancel(t *testing.T) {
+	a := newTestApp()
+	provider := &fakeProvider{delay: 50 * time.Millisecond}
+	a.providers = map[string]query.Provider{"default": provider}
+	a.concurrency = 2
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	a.pollCycle(ctx, []string{"a", "b", "c", "d", "e", "f"}, nil)
+
+	// The two requests in flight when cancelled complete and are cached.
+	if len(provider.queried) != 2 {
+		t.Errorf("Expected 2 outlets to be queried before cancellation, got %d", len(provider.queried))
+	}
+	for outletId := range provider.queried {
+		if _, ok := a.cache.Get(outletId); !ok {
+			t.Errorf("Expected in-flight result for %s to be cached", outletId)
+		}
+	}
+}
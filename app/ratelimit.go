@@ -0,0 +1,85 @@
+package app
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clientLimiter allows each client address limit requests per window. Counts
+// are kept for the current window only, so memory stays bounded by the
+// clients seen within one window.
+type clientLimiter struct {
+	limit  int
+	window time.Duration
+
+	mu     sync.Mutex
+	start  time.Time
+	counts map[string]int
+}
+
+func newClientLimiter(limit int, window time.Duration) *clientLimiter {
+	return &clientLimiter{limit: limit, window: window, counts: make(map[string]int)}
+}
+
+// allow counts a request from client and reports whether it is within the
+// limit, or else how long until the window ends.
+func (l *clientLimiter) allow(client string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.start) >= l.window {
+		l.start = now
+		clear(l.counts)
+	}
+	if l.counts[client] >= l.limit {
+		return false, l.window - now.Sub(l.start)
+	}
+	l.counts[client]++
+	return true, 0
+}
+
+// clientAddress is the address of the client, without the port. A request
+// from a trusted proxy is attributed to the address it forwarded for: the
+// rightmost X-Forwarded-For entry that is not itself a trusted proxy, or
+// else X-Real-IP.
+func (a *App) clientAddress(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !a.trustedProxy(host) {
+		return host
+	}
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addr := strings.TrimSpace(forwarded[i])
+		if _, err := netip.ParseAddr(addr); err != nil {
+			// Anything left of a malformed entry cannot be trusted either.
+			break
+		}
+		if host = addr; !a.trustedProxy(addr) {
+			return addr
+		}
+	}
+	if addr, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+		return addr.String()
+	}
+	return host
+}
+
+func (a *App) trustedProxy(host string) bool {
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range a.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,36 @@
+package app
+
+import (
+	"charge-monitor/session"
+	"cmp"
+	"net/http"
+	"slices"
+	"time"
+)
+
+type sessionsView struct {
+	From     int64             `json:"from"`
+	To       int64             `json:"to"`
+	Sessions []session.Session `json:"sessions"`
+}
+
+// getSessions returns the charging sessions that overlap [from, to), by
+// default the last 7 days, including those still ongoing. The outlet and
+// station parameters narrow them down like they do for /events.
+func (a *App) getSessions(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	from, to, err := parseRange(r, 7*24*time.Hour)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	filter := newEventFilter(query)
+	sessions := []session.Session{}
+	for _, s := range append(a.sessionLog.Range(from, to), a.sessions.Ongoing()...) {
+		if s.Start < to && s.End >= from && filter.matchOutlet(s.OutletID, s.StationID) {
+			sessions = append(sessions, s)
+		}
+	}
+	slices.SortStableFunc(sessions, func(a, b session.Session) int { return cmp.Compare(a.Start, b.Start) })
+	writeJSON(w, http.StatusOK, sessionsView{From: from, To: to, Sessions: sessions})
+}
@@ -0,0 +1,51 @@
+package app
+
+import (
+	"charge-monitor/cache"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestGetSessions(t *testing.T) {
+	a := newTestApp()
+	a.cache.Set("outlet-1", cache.OutletInfo{State: cache.StateCharging, Power: "90W", UsedMinutes: 10, LastSuccessAt: 1600})
+	a.cache.Set("outlet-1", cache.OutletInfo{State: cache.StateIdle, LastSuccessAt: 2200})
+	a.cache.Set("outlet-7", cache.OutletInfo{State: cache.StateCharging, Power: "120W", UsedMinutes: 5, LastSuccessAt: 1900})
+
+	get := func(target string) sessionsView {
+		rec := doRequest(t, a.routes(), http.MethodGet, target, "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 for %s, got %d: %s", target, rec.Code, rec.Body)
+		}
+		var view sessionsView
+		if err := json.Unmarshal(rec.Body.Bytes(), &view); err != nil {
+			t.Fatalf("Response is not valid JSON: %v", err)
+		}
+		return view
+	}
+
+	view := get("/sessions?from=0&to=3000")
+	if len(view.Sessions) != 2 {
+		t.Fatalf("Expected 2 sessions, got %+v", view.Sessions)
+	}
+	if s := view.Sessions[0]; s.OutletID != "outlet-1" || s.Start != 1000 || s.StationID != "xzy-4" || s.Ongoing {
+		t.Errorf("Unexpected finished session %+v", s)
+	}
+	if s := view.Sessions[1]; s.OutletID != "outlet-7" || !s.Ongoing {
+		t.Errorf("Expected the session of outlet-7 to be ongoing, got %+v", s)
+	}
+
+	if view := get("/sessions?from=0&to=3000&outlet=outlet-7"); len(view.Sessions) != 1 {
+		t.Errorf("Expected the outlet filter to keep 1 session, got %+v", view.Sessions)
+	}
+	if view := get("/sessions?from=0&to=3000&station=other"); len(view.Sessions) != 0 {
+		t.Errorf("Expected no sessions for another station, got %+v", view.Sessions)
+	}
+	if view := get("/sessions?from=0&to=1000"); len(view.Sessions) != 0 {
+		t.Errorf("Expected no sessions starting after the range, got %+v", view.Sessions)
+	}
+	if rec := doRequest(t, a.routes(), http.MethodGet, "/sessions?from=yesterday", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected an invalid from to be rejected, got %d", rec.Code)
+	}
+}
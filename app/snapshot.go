@@ -0,0 +1,78 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// restoreSnapshot loads the cache from the snapshot file, if there is one.
+func (a *App) restoreSnapshot() error {
+	if a.snapshotPath == "" {
+		return nil
+	}
+	data, err := os.ReadFile(a.snapshotPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := a.cache.LoadFromJSON(data); err != nil {
+		return err
+	}
+	slog.Info("Restored cache snapshot", "path", a.snapshotPath)
+	return nil
+}
+
+// saveSnapshot writes the cache to a temporary file and renames it over the
+// snapshot, so readers never see a partially written file.
+func (a *App) saveSnapshot() error {
+	if a.snapshotPath == "" {
+		return nil
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(a.snapshotPath), filepath.Base(a.snapshotPath)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	// CreateTemp uses 0600, but the snapshot is meant to be inspected.
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(a.cache.JSON()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), a.snapshotPath)
+}
+
+func (a *App) snapshotLoop(ctx context.Context) {
+	if a.snapshotPath == "" || a.snapshotInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(a.snapshotInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := a.saveSnapshot(); err != nil {
+				slog.Error("Failed to save cache snapshot", "path", a.snapshotPath, "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package app
+
+import (
+	"charge-monitor/cache"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSnapshot_SaveAndRestore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.json")
+
+	a := newTestApp()
+	a.snapshotPath = path
+	a.cache.Set("outlet-7", cache.OutletInfo{Power: "88W", Watts: 88, State: cache.StateCharging})
+	if err := a.Close(); err != nil {
+		t.Fatalf("Failed to flush snapshot: %v", err)
+	}
+
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("Expected only the snapshot file to remain, got %d entries", len(entries))
+	}
+
+	restored := newTestApp()
+	restored.snapshotPath = path
+	if err := restored.restoreSnapshot(); err != nil {
+		t.Fatalf("Failed to restore snapshot: %v", err)
+	}
+	info, ok := restored.cache.Get("outlet-7")
+	if !ok {
+		t.Fatal("Expected outlet-7 to be restored")
+	}
+	if info.Power != "88W" || info.State != cache.StateCharging || !info.Stale {
+		t.Errorf("Expected restored stale charging entry, got %+v", info)
+	}
+}
+
+func TestSnapshot_RestoreMissingFile(t *testing.T) {
+	a := newTestApp()
+	a.snapshotPath = filepath.Join(t.TempDir(), "missing.json")
+	if err := a.restoreSnapshot(); err != nil {
+		t.Errorf("Expected a missing snapshot to be ignored, got %v", err)
+	}
+}
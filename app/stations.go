@@ -0,0 +1,88 @@
+package app
+
+import (
+	"charge-monitor/cache"
+	"charge-monitor/config"
+	"net/http"
+)
+
+type stationSummary struct {
+	ID              string  `json:"id"`
+	Name            string  `json:"name"`
+	Campus          string  `json:"campus,omitempty"`
+	Area            string  `json:"area,omitempty"`
+	Latitude        float64 `json:"latitude,omitempty"`
+	Longitude       float64 `json:"longitude,omitempty"`
+	Total           int     `json:"total"`
+	Free            int     `json:"free"`
+	Busy            int     `json:"busy"`
+	Unknown         int     `json:"unknown"`
+	Offline         int     `json:"offline"`
+	PowerWatts      float64 `json:"power_watts"`
+	OldestUpdatedAt int64   `json:"oldest_updated_at"`
+}
+
+type stationDetail struct {
+	stationSummary
+	Outlets []outletView `json:"outlets"`
+}
+
+// summarizeStation aggregates the cached status of every outlet of a station,
+// looked up in infos. Outlets that have not been polled yet count as unknown;
+// finished sessions still occupy the socket and count as busy.
+func summarizeStation(station *config.Station, infos map[string]cache.OutletInfo) stationDetail {
+	detail := stationDetail{
+		stationSummary: stationSummary{
+			ID:        station.ID,
+			Name:      station.Name,
+			Campus:    station.Campus,
+			Area:      station.Area,
+			Latitude:  station.Latitude,
+			Longitude: station.Longitude,
+			Total:     len(station.Outlets),
+		},
+		Outlets: make([]outletView, 0, len(station.Outlets)),
+	}
+	for _, outlet := range station.Outlets {
+		info, ok := infos[outlet.ID]
+		detail.Outlets = append(detail.Outlets, newOutletView(config.OutletRef{Outlet: outlet, Station: station}, info))
+		if !ok {
+			detail.Unknown++
+			continue
+		}
+		switch info.State {
+		case cache.StateIdle:
+			detail.Free++
+		case cache.StateCharging, cache.StateFinished:
+			detail.Busy++
+		case cache.StateOffline:
+			detail.Offline++
+		default:
+			detail.Unknown++
+		}
+		detail.PowerWatts += info.Watts
+		if detail.OldestUpdatedAt == 0 || info.UpdatedAt < detail.OldestUpdatedAt {
+			detail.OldestUpdatedAt = info.UpdatedAt
+		}
+	}
+	return detail
+}
+
+func (a *App) getStations(w http.ResponseWriter, r *http.Request) {
+	catalog := a.getCatalog()
+	infos := a.cache.GetMany(catalog.OutletIDs())
+	stations := make([]stationSummary, 0, len(catalog.Stations))
+	for i := range catalog.Stations {
+		stations = append(stations, summarizeStation(&catalog.Stations[i], infos).stationSummary)
+	}
+	writeJSON(w, http.StatusOK, stations)
+}
+
+func (a *App) getStation(w http.ResponseWriter, r *http.Request) {
+	station, ok := a.getCatalog().Station(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "station not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, summarizeStation(station, a.cache.GetMany(station.OutletIDs())))
+}
@@ -0,0 +1,47 @@
+package app
+
+import (
+	"charge-monitor/cache"
+	"charge-monitor/config"
+	"testing"
+)
+
+func TestSummarizeStation(t *testing.T) {
+	station := &config.Station{
+		ID:   "xzy-1",
+		Name: "清水河学知苑1号充电桩",
+		Outlets: []config.Outlet{
+			{ID: "outlet-1", Socket: 1},
+			{ID: "outlet-2", Socket: 2},
+			{ID: "outlet-3", Socket: 3},
+			{ID: "outlet-4", Socket: 4},
+			{ID: "outlet-5", Socket: 5},
+			{ID: "outlet-6", Socket: 6},
+		},
+	}
+	c := cache.NewLocalCache()
+	c.Set("outlet-1", cache.OutletInfo{Power: "88W", Watts: 88, UsedMinutes: 10, State: cache.StateCharging})
+	c.Set("outlet-2", cache.OutletInfo{Power: "120W", Watts: 120, UsedMinutes: 30, State: cache.StateCharging})
+	c.Set("outlet-3", cache.OutletInfo{Power: "", State: cache.StateIdle})
+	c.Set("outlet-4", cache.OutletInfo{Power: "", UsedMinutes: 240, State: cache.StateFinished})
+	c.Set("outlet-5", cache.OutletInfo{State: cache.StateOffline})
+
+	detail := summarizeStation(station, c.GetMany(station.OutletIDs()))
+
+	if detail.Total != 6 {
+		t.Errorf("Expected total 6, got %d", detail.Total)
+	}
+	if detail.Busy != 3 || detail.Free != 1 || detail.Offline != 1 || detail.Unknown != 1 {
+		t.Errorf("Expected 3 busy, 1 free, 1 offline, 1 unknown, got %d busy, %d free, %d offline, %d unknown",
+			detail.Busy, detail.Free, detail.Offline, detail.Unknown)
+	}
+	if detail.PowerWatts != 208 {
+		t.Errorf("Expected total power 208, got %v", detail.PowerWatts)
+	}
+	if detail.OldestUpdatedAt == 0 {
+		t.Error("Expected oldest UpdatedAt to be set")
+	}
+	if len(detail.Outlets) != 6 || detail.Outlets[1].StationID != "xzy-1" || detail.Outlets[1].Socket != 2 {
+		t.Errorf("Unexpected outlets %+v", detail.Outlets)
+	}
+}
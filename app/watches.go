@@ -0,0 +1,265 @@
+package app
+
+import (
+	"charge-monitor/cache"
+	"charge-monitor/config"
+	"charge-monitor/notify"
+	"charge-monitor/watch"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// watchRequest asks to be told when a station has a free outlet, or when an
+// outlet finishes charging; exactly one of StationID and OutletID is set.
+type watchRequest struct {
+	StationID string `json:"station_id"`
+	OutletID  string `json:"outlet_id"`
+	Channel   string `json:"channel"`
+	Target    string `json:"target"`
+	// ExpiresIn is the lifetime of the watch in seconds.
+	ExpiresIn int64 `json:"expires_in"`
+}
+
+// notifyTimeout bounds sending one notification.
+const notifyTimeout = 30 * time.Second
+
+// handleWatches registers a watch that notifies its target once, when an
+// outlet of the station becomes free or when the outlet finishes charging. A
+// station that already has a free outlet, or an outlet that is not charging,
+// is answered with 409, as there is nothing to wait for. Anyone may register
+// watches, so each client address gets a few per hour.
+func (a *App) handleWatches(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var req watchRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16<<10)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid watch: "+err.Error())
+		return
+	}
+	if (req.StationID == "") == (req.OutletID == "") {
+		writeError(w, http.StatusBadRequest, "exactly one of station_id and outlet_id is required")
+		return
+	}
+	created := watch.Watch{Channel: req.Channel, Target: req.Target}
+	catalog := a.getCatalog()
+	if req.StationID != "" {
+		station, ok := catalog.Station(req.StationID)
+		if !ok {
+			writeError(w, http.StatusNotFound, "station not found")
+			return
+		}
+		if summarizeStation(station, a.cache.GetMany(station.OutletIDs())).Free > 0 {
+			writeError(w, http.StatusConflict, "station already has a free outlet")
+			return
+		}
+		created.Kind = watch.KindStationFree
+		created.StationID = station.ID
+	} else {
+		if _, ok := catalog.Outlet(req.OutletID); !ok {
+			writeError(w, http.StatusNotFound, "outlet not found")
+			return
+		}
+		if info, _ := a.cache.Get(req.OutletID); info.State != cache.StateCharging {
+			writeError(w, http.StatusConflict, "outlet is not charging")
+			return
+		}
+		created.Kind = watch.KindOutletFinished
+		created.OutletID = req.OutletID
+	}
+	if err := a.notifiers.Validate(req.Channel, req.Target); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	ttl := a.watchTTL
+	if req.ExpiresIn != 0 {
+		ttl = time.Duration(req.ExpiresIn) * time.Second
+		if ttl <= 0 || ttl > a.watchMaxTTL {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("expires_in must be between 1 and %d seconds", int64(a.watchMaxTTL/time.Second)))
+			return
+		}
+	}
+	now := time.Now()
+	if ok, retryAfter := a.watchClients.allow(a.clientAddress(r), now); !ok {
+		w.Header().Set("Retry-After", strconv.FormatInt(int64(retryAfter.Seconds())+1, 10))
+		writeError(w, http.StatusTooManyRequests, "too many watches from this client")
+		return
+	}
+	created.ExpiresAt = now.Add(ttl).Unix()
+	created, err := a.watches.Add(created, now)
+	if errors.Is(err, watch.ErrFull) {
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	if err != nil {
+		slog.Error("Failed to store watch", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to store watch")
+		return
+	}
+	writeJSON(w, http.StatusCreated, created)
+}
+
+func (a *App) handleWatch(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	switch r.Method {
+	case http.MethodGet:
+		found, ok := a.watches.Get(id, time.Now())
+		if !ok {
+			writeError(w, http.StatusNotFound, "watch not found")
+			return
+		}
+		writeJSON(w, http.StatusOK, found)
+	case http.MethodDelete:
+		if !a.watches.Remove(id) {
+			writeError(w, http.StatusNotFound, "watch not found")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// watchQueueSize is how many changes may wait for firing watches before
+// further ones are dropped.
+const watchQueueSize = 1024
+
+// watchFiring is a change waiting for firing watches, and when it was seen.
+type watchFiring struct {
+	change cache.Change
+	at     time.Time
+}
+
+// queueWatches hands a change to runWatches without blocking, as cache
+// subscribers must not.
+func (a *App) queueWatches(c cache.Change) {
+	a.notifyMu.Lock()
+	defer a.notifyMu.Unlock()
+	if a.notifyClosed {
+		return
+	}
+	a.notifications.Add(1)
+	select {
+	case a.watchQueue <- watchFiring{change: c, at: time.Now()}:
+	default:
+		a.notifications.Done()
+		slog.Warn("Dropped change for watches, queue is full", "outletId", c.OutletID)
+	}
+}
+
+// runWatches fires watches for queued changes, in order, until Close.
+func (a *App) runWatches() {
+	for firing := range a.watchQueue {
+		a.fireWatches(firing.change, firing.at)
+		a.notifications.Done()
+	}
+}
+
+// fireWatches notifies and removes the station watches of an outlet that
+// became free and the outlet watches of one that finished charging.
+func (a *App) fireWatches(c cache.Change, now time.Time) {
+	stalled := a.stalls.observe(c, now) >= a.stallAfter
+	ref, _ := a.getCatalog().Outlet(c.OutletID)
+	outlet := newOutletView(ref, c.Current)
+	station, socket := describeOutlet(ref, outlet)
+
+	if c.Has(cache.EventBecameFree) && ref.Station != nil {
+		watches := a.watches.Take(watch.KindStationFree, ref.Station.ID, now)
+		a.sendWatchNotifications(watches, notify.Message{
+			Event:   watch.KindStationFree,
+			Subject: fmt.Sprintf("%s: socket %s is free", station, socket),
+			Text:    fmt.Sprintf("Socket %s of %s is free now.", socket, station),
+		}, map[string]any{"outlet": outlet})
+	}
+
+	var reason string
+	switch {
+	case c.Has(cache.EventFinishedCharging):
+		reason = "state"
+	case stalled:
+		// Some sessions keep reporting power after the battery is full, but
+		// the used minutes stop growing.
+		reason = "stalled"
+	default:
+		return
+	}
+	watches := a.watches.Take(watch.KindOutletFinished, c.OutletID, now)
+	a.sendWatchNotifications(watches, notify.Message{
+		Event:   watch.KindOutletFinished,
+		Subject: fmt.Sprintf("%s: socket %s finished charging", station, socket),
+		Text:    fmt.Sprintf("Socket %s of %s finished charging after %d minutes.", socket, station, c.Current.UsedMinutes),
+	}, map[string]any{"outlet": outlet, "reason": reason})
+}
+
+// sendWatchNotifications sends msg to each watch in the background, adding
+// the watch ID to data. It runs on runWatches, which Close waits for, so the
+// messages are always waited for too.
+func (a *App) sendWatchNotifications(watches []watch.Watch, msg notify.Message, data map[string]any) {
+	for _, fired := range watches {
+		payload := map[string]any{"watch_id": fired.ID}
+		maps.Copy(payload, data)
+		sent := msg
+		sent.Data = payload
+		a.notifications.Go(func() {
+			ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+			defer cancel()
+			if err := a.notifiers.Notify(ctx, fired.Channel, fired.Target, sent); err != nil {
+				slog.Error("Failed to send watch notification", "watch", fired.ID, "channel", fired.Channel, "error", err)
+			}
+		})
+	}
+}
+
+// describeOutlet names an outlet's station and socket for humans.
+func describeOutlet(ref config.OutletRef, outlet outletView) (station, socket string) {
+	if ref.Station != nil {
+		station = cmp.Or(ref.Station.Name, ref.Station.ID)
+	}
+	socket = outlet.Label
+	if socket == "" && outlet.Socket > 0 {
+		socket = fmt.Sprintf("#%d", outlet.Socket)
+	}
+	return station, cmp.Or(socket, outlet.ID)
+}
+
+// stallTracker remembers when the used minutes of each charging outlet last
+// grew.
+type stallTracker struct {
+	mu    sync.Mutex
+	marks map[string]usageMark
+}
+
+type usageMark struct {
+	minutes int64
+	since   time.Time
+}
+
+// observe returns how long the used minutes of a charging outlet have not
+// grown, and forgets outlets that are not charging.
+func (s *stallTracker) observe(c cache.Change, now time.Time) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c.Current.State != cache.StateCharging {
+		delete(s.marks, c.OutletID)
+		return 0
+	}
+	if s.marks == nil {
+		s.marks = make(map[string]usageMark)
+	}
+	mark, ok := s.marks[c.OutletID]
+	if !ok || c.Current.UsedMinutes != mark.minutes {
+		s.marks[c.OutletID] = usageMark{minutes: c.Current.UsedMinutes, since: now}
+		return 0
+	}
+	return now.Sub(mark.since)
+}
@@ -0,0 +1,229 @@
+package app
+
+import (
+	"charge-monitor/cache"
+	"charge-monitor/notify"
+	"charge-monitor/watch"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingNotifier keeps the messages it is asked to send.
+type recordingNotifier struct {
+	mu   sync.Mutex
+	sent map[string][]notify.Message
+}
+
+func (n *recordingNotifier) Validate(target string) error { return nil }
+
+func (n *recordingNotifier) Notify(ctx context.Context, target string, msg notify.Message) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.sent == nil {
+		n.sent = make(map[string][]notify.Message)
+	}
+	n.sent[target] = append(n.sent[target], msg)
+	return nil
+}
+
+func TestWatch_NotifiesOnceWhenStationFrees(t *testing.T) {
+	a := newTestApp()
+	notifier := &recordingNotifier{}
+	a.notifiers = notify.Channels{"test": notifier}
+	routes := a.routes()
+	a.cache.Set("outlet-1", cache.OutletInfo{Power: "88W", State: cache.StateCharging})
+	a.cache.Set("outlet-7", cache.OutletInfo{Power: "88W", State: cache.StateCharging})
+
+	w := doRequest(t, routes, "POST", "/watches", `{"station_id":"xzy-4","channel":"test","target":"student","expires_in":600}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status code 201, got %d: %s", w.Code, w.Body)
+	}
+	var created struct{ ID string }
+	json.Unmarshal(w.Body.Bytes(), &created)
+	if w := doRequest(t, routes, "GET", "/watches/"+created.ID, ""); w.Code != http.StatusOK {
+		t.Errorf("Expected status code 200, got %d", w.Code)
+	}
+
+	a.cache.Set("outlet-7", cache.OutletInfo{Power: "0W", State: cache.StateIdle})
+	a.cache.Set("outlet-1", cache.OutletInfo{Power: "0W", State: cache.StateIdle})
+	a.notifications.Wait()
+
+	sent := notifier.sent["student"]
+	if len(sent) != 1 {
+		t.Fatalf("Expected a single notification, got %d", len(sent))
+	}
+	if sent[0].Event != "station_free" || !strings.Contains(sent[0].Subject, "#7") {
+		t.Errorf("Unexpected message %+v", sent[0])
+	}
+	if w := doRequest(t, routes, "GET", "/watches/"+created.ID, ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected the watch to be removed after firing, got status code %d", w.Code)
+	}
+}
+
+func TestWatch_InvalidRequests(t *testing.T) {
+	a := newTestApp()
+	a.notifiers = notify.Channels{"test": &recordingNotifier{}}
+	routes := a.routes()
+
+	for body, expected := range map[string]int{
+		`{"station_id":"missing","channel":"test","target":"x"}`:                http.StatusNotFound,
+		`{"station_id":"xzy-4","channel":"pigeon","target":"x"}`:                http.StatusBadRequest,
+		`{"station_id":"xzy-4","channel":"test","target":"x","expires_in":-1}`:  http.StatusBadRequest,
+		`{"station_id":"xzy-4","channel":"test","target":"x","expires_in":1e9}`: http.StatusBadRequest,
+		`{`: http.StatusBadRequest,
+	} {
+		if w := doRequest(t, routes, "POST", "/watches", body); w.Code != expected {
+			t.Errorf("%s: expected status code %d, got %d", body, expected, w.Code)
+		}
+	}
+
+	a.cache.Set("outlet-1", cache.OutletInfo{State: cache.StateIdle})
+	if w := doRequest(t, routes, "POST", "/watches", `{"station_id":"xzy-4","channel":"test","target":"x"}`); w.Code != http.StatusConflict {
+		t.Errorf("Expected status code 409 for a station with a free outlet, got %d", w.Code)
+	}
+	if w := doRequest(t, routes, "POST", "/watches", `{"outlet_id":"outlet-1","channel":"test","target":"x"}`); w.Code != http.StatusConflict {
+		t.Errorf("Expected status code 409 for an outlet that is not charging, got %d", w.Code)
+	}
+}
+
+func TestWatch_ClientLimit(t *testing.T) {
+	a := newTestApp()
+	a.notifiers = notify.Channels{"test": &recordingNotifier{}}
+	a.watchClients = newClientLimiter(2, time.Hour)
+	routes := a.routes()
+
+	a.cache.Set("outlet-1", cache.OutletInfo{Power: "88W", State: cache.StateCharging})
+
+	body := `{"outlet_id":"outlet-1","channel":"test","target":"x"}`
+	for range 2 {
+		if w := doRequest(t, routes, "POST", "/watches", body); w.Code != http.StatusCreated {
+			t.Fatalf("Expected status code 201, got %d", w.Code)
+		}
+	}
+	w := doRequest(t, routes, "POST", "/watches", body)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status code 429, got %d", w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("Expected a Retry-After header")
+	}
+
+	other := newClientLimiter(2, time.Hour)
+	now := time.Now()
+	other.allow("192.0.2.1", now)
+	other.allow("192.0.2.1", now)
+	if ok, _ := other.allow("192.0.2.2", now); !ok {
+		t.Error("Expected clients to be counted separately")
+	}
+	if ok, _ := other.allow("192.0.2.1", now.Add(time.Hour)); !ok {
+		t.Error("Expected the count to reset with the window")
+	}
+}
+
+// slowWatches is a watch store whose lookups wait for release.
+type slowWatches struct {
+	watch.Store
+	release chan struct{}
+}
+
+func (s *slowWatches) Take(kind, subject string, now time.Time) []watch.Watch {
+	<-s.release
+	return s.Store.Take(kind, subject, now)
+}
+
+func TestWatch_FiringDoesNotBlockChanges(t *testing.T) {
+	a := newTestApp()
+	watches := &slowWatches{Store: a.watches, release: make(chan struct{})}
+	a.watches = watches
+	a.cache.Set("outlet-1", cache.OutletInfo{Power: "88W", State: cache.StateCharging})
+
+	set := make(chan struct{})
+	go func() {
+		a.cache.Set("outlet-1", cache.OutletInfo{Power: "0W", State: cache.StateIdle})
+		close(set)
+	}()
+	select {
+	case <-set:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected Set not to wait for the watch store")
+	}
+	close(watches.release)
+	a.notifications.Wait()
+}
+
+func TestClientAddress(t *testing.T) {
+	a := newTestApp()
+	a.trustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+
+	for _, c := range []struct {
+		remote, forwardedFor, realIP, expected string
+	}{
+		{"192.0.2.1:1234", "198.51.100.7", "", "192.0.2.1"},
+		{"10.0.0.2:1234", "", "", "10.0.0.2"},
+		{"10.0.0.2:1234", "198.51.100.7", "", "198.51.100.7"},
+		// Only the entries added by trusted proxies are believed.
+		{"10.0.0.2:1234", "203.0.113.9, 198.51.100.7, 10.0.0.3", "", "198.51.100.7"},
+		{"10.0.0.2:1234", "", "198.51.100.7", "198.51.100.7"},
+	} {
+		r := httptest.NewRequest("POST", "/watches", nil)
+		r.RemoteAddr = c.remote
+		if c.forwardedFor != "" {
+			r.Header.Set("X-Forwarded-For", c.forwardedFor)
+		}
+		if c.realIP != "" {
+			r.Header.Set("X-Real-IP", c.realIP)
+		}
+		if address := a.clientAddress(r); address != c.expected {
+			t.Errorf("%+v: expected %s, got %s", c, c.expected, address)
+		}
+	}
+}
+
+func TestWatch_NotifiesWhenOutletFinishes(t *testing.T) {
+	a := newTestApp()
+	notifier := &recordingNotifier{}
+	a.notifiers = notify.Channels{"test": notifier}
+	// Only the full outlet keeps charging with the same used minutes.
+	a.stallAfter = time.Nanosecond
+	routes := a.routes()
+
+	for _, target := range []string{"unplugged", "full"} {
+		if target == "unplugged" {
+			a.cache.Set("outlet-7", cache.OutletInfo{Power: "88W", UsedMinutes: 30, State: cache.StateCharging})
+		} else {
+			a.cache.Set("outlet-7", cache.OutletInfo{Power: "88W", UsedMinutes: 40, State: cache.StateCharging})
+		}
+		body := `{"outlet_id":"outlet-7","channel":"test","target":"` + target + `"}`
+		if w := doRequest(t, routes, "POST", "/watches", body); w.Code != http.StatusCreated {
+			t.Fatalf("Expected status code 201, got %d: %s", w.Code, w.Body)
+		}
+		if target == "unplugged" {
+			a.cache.Set("outlet-7", cache.OutletInfo{Power: "0W", UsedMinutes: 31, State: cache.StateIdle})
+		} else {
+			a.cache.Set("outlet-7", cache.OutletInfo{Power: "88W", UsedMinutes: 41, State: cache.StateCharging})
+			a.cache.Set("outlet-7", cache.OutletInfo{Power: "88W", UsedMinutes: 41, State: cache.StateCharging})
+		}
+		a.notifications.Wait()
+	}
+
+	for target, reason := range map[string]string{"unplugged": "state", "full": "stalled"} {
+		sent := notifier.sent[target]
+		if len(sent) != 1 {
+			t.Fatalf("%s: expected a single notification, got %d", target, len(sent))
+		}
+		data := sent[0].Data.(map[string]any)
+		if sent[0].Event != "outlet_finished" || data["reason"] != reason {
+			t.Errorf("%s: expected a finished notification because of %s, got %+v", target, reason, sent[0])
+		}
+	}
+	if w := doRequest(t, routes, "POST", "/watches", `{"station_id":"xzy-4","outlet_id":"outlet-7","channel":"test","target":"x"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code 400 for a watch on both a station and an outlet, got %d", w.Code)
+	}
+}
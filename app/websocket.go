@@ -0,0 +1,184 @@
+package app
+
+import (
+	"charge-monitor/cache"
+	"charge-monitor/config"
+	"encoding/json"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// wsRequest is a message from a WebSocket client.
+type wsRequest struct {
+	// Action is "subscribe", "unsubscribe" or "ping".
+	Action   string   `json:"action"`
+	Outlets  []string `json:"outlets,omitempty"`
+	Stations []string `json:"stations,omitempty"`
+}
+
+// wsMessage is a message to a WebSocket client. Type is "change",
+// "subscribed", "unsubscribed", "heartbeat", "pong" or "error".
+type wsMessage struct {
+	Type    string  `json:"type"`
+	EventID string  `json:"event_id,omitempty"`
+	Change  *change `json:"change,omitempty"`
+	// Outlets and Stations are the subscription after a subscribe or
+	// unsubscribe; Status holds the current status of newly subscribed outlets.
+	Outlets  []string     `json:"outlets,omitempty"`
+	Stations []string     `json:"stations,omitempty"`
+	Status   []outletView `json:"status,omitempty"`
+	Time     int64        `json:"time,omitempty"`
+	Error    string       `json:"error,omitempty"`
+}
+
+const wsWriteTimeout = 10 * time.Second
+
+// websocketHandler accepts clients without an Origin header, such as mobile
+// apps, like the CORS policy of the rest of the API.
+func (a *App) websocketHandler() http.HandlerFunc {
+	server := websocket.Server{
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler:   a.serveWebSocket,
+	}
+	return server.ServeHTTP
+}
+
+// serveWebSocket pushes the changes of the outlets and stations the client
+// subscribed to, plus a heartbeat while idle. Only this goroutine writes to
+// the connection; requests are read on another and handed over.
+func (a *App) serveWebSocket(ws *websocket.Conn) {
+	defer ws.Close()
+	_, events, _ := a.events.subscribe("")
+	defer a.events.unsubscribe(events)
+
+	requests := make(chan []byte)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(requests)
+		for {
+			var data []byte
+			if err := websocket.Message.Receive(ws, &data); err != nil {
+				return
+			}
+			select {
+			case requests <- data:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	filter := eventFilter{outlets: make(map[string]bool), stations: make(map[string]bool)}
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		var msg wsMessage
+		select {
+		case e, open := <-events:
+			if !open {
+				return
+			}
+			if filter.empty() || !filter.match(e.change) {
+				continue
+			}
+			msg = wsMessage{Type: "change", EventID: a.events.eventID(e), Change: &e.change}
+		case data, open := <-requests:
+			if !open {
+				return
+			}
+			msg = a.handleWebSocketRequest(data, filter)
+		case now := <-heartbeat.C:
+			msg = wsMessage{Type: "heartbeat", Time: now.Unix()}
+		}
+		ws.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		if err := websocket.JSON.Send(ws, msg); err != nil {
+			slog.Debug("Failed to write to WebSocket client", "error", err)
+			return
+		}
+	}
+}
+
+// handleWebSocketRequest applies a client request to filter and returns the
+// reply.
+func (a *App) handleWebSocketRequest(data []byte, filter eventFilter) wsMessage {
+	var req wsRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return wsMessage{Type: "error", Error: "invalid request: " + err.Error()}
+	}
+	catalog := a.getCatalog()
+	switch req.Action {
+	case "ping":
+		return wsMessage{Type: "pong", Time: time.Now().Unix()}
+	case "subscribe":
+		// An empty filter matches every outlet, so subscribing to nothing
+		// would reply with the status of all of them.
+		if len(req.Outlets) == 0 && len(req.Stations) == 0 {
+			return wsMessage{Type: "error", Error: "subscribe needs outlets or stations"}
+		}
+		var unknown []string
+		for _, id := range req.Outlets {
+			if _, ok := catalog.Outlet(id); !ok {
+				unknown = append(unknown, "outlet "+id)
+			}
+		}
+		for _, id := range req.Stations {
+			if _, ok := catalog.Station(id); !ok {
+				unknown = append(unknown, "station "+id)
+			}
+		}
+		if len(unknown) > 0 {
+			return wsMessage{Type: "error", Error: "unknown " + strings.Join(unknown, ", ")}
+		}
+		added := eventFilter{outlets: idSet(req.Outlets), stations: idSet(req.Stations)}
+		maps.Copy(filter.outlets, added.outlets)
+		maps.Copy(filter.stations, added.stations)
+		msg := filter.message("subscribed")
+		msg.Status = []outletView{}
+		var matched []config.OutletRef
+		for _, id := range catalog.OutletIDs() {
+			ref, _ := catalog.Outlet(id)
+			if added.match(change{outletView: newOutletView(ref, cache.OutletInfo{})}) {
+				matched = append(matched, ref)
+			}
+		}
+		ids := make([]string, len(matched))
+		for i, ref := range matched {
+			ids[i] = ref.ID
+		}
+		infos := a.cache.GetMany(ids)
+		for _, ref := range matched {
+			msg.Status = append(msg.Status, newOutletView(ref, infos[ref.ID]))
+		}
+		return msg
+	case "unsubscribe":
+		for _, id := range req.Outlets {
+			delete(filter.outlets, id)
+		}
+		for _, id := range req.Stations {
+			delete(filter.stations, id)
+		}
+		return filter.message("unsubscribed")
+	}
+	return wsMessage{Type: "error", Error: "unknown action " + strconv.Quote(req.Action)}
+}
+
+func (f eventFilter) empty() bool {
+	return len(f.outlets) == 0 && len(f.stations) == 0
+}
+
+// message returns a reply of the given type listing the subscription.
+func (f eventFilter) message(kind string) wsMessage {
+	return wsMessage{
+		Type:     kind,
+		Outlets:  slices.Sorted(maps.Keys(f.outlets)),
+		Stations: slices.Sorted(maps.Keys(f.stations)),
+	}
+}
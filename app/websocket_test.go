@@ -0,0 +1,111 @@
+package app
+
+import (
+	"charge-monitor/cache"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+func dialWebSocket(t *testing.T, server *httptest.Server) *websocket.Conn {
+	t.Helper()
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws", "", server.URL)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	t.Cleanup(func() { ws.Close() })
+	return ws
+}
+
+func exchange(t *testing.T, ws *websocket.Conn, req wsRequest) wsMessage {
+	t.Helper()
+	if err := websocket.JSON.Send(ws, req); err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	return receive(t, ws)
+}
+
+func receive(t *testing.T, ws *websocket.Conn) wsMessage {
+	t.Helper()
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var msg wsMessage
+	if err := websocket.JSON.Receive(ws, &msg); err != nil {
+		t.Fatalf("Failed to receive message: %v", err)
+	}
+	return msg
+}
+
+func TestWebSocket_SubscribeAndUnsubscribe(t *testing.T) {
+	a := newTestApp()
+	server := httptest.NewServer(a.routes())
+	defer server.Close()
+	defer a.events.close()
+	a.cache.Set("outlet-1", cache.OutletInfo{Power: "10W", State: cache.StateCharging})
+	ws := dialWebSocket(t, server)
+
+	msg := exchange(t, ws, wsRequest{Action: "subscribe", Outlets: []string{"outlet-7"}})
+	if msg.Type != "subscribed" || len(msg.Outlets) != 1 || len(msg.Status) != 1 || msg.Status[0].State != cache.StateUnknown {
+		t.Fatalf("Unexpected reply %+v", msg)
+	}
+
+	// outlet-1 is not subscribed, so only the change of outlet-7 arrives.
+	a.cache.Set("outlet-1", cache.OutletInfo{Power: "0W", State: cache.StateIdle})
+	a.cache.Set("outlet-7", cache.OutletInfo{Power: "88W", State: cache.StateCharging})
+	msg = receive(t, ws)
+	if msg.Type != "change" || msg.Change == nil || msg.Change.ID != "outlet-7" || msg.Change.State != cache.StateCharging {
+		t.Fatalf("Expected the change of outlet-7, got %+v", msg)
+	}
+
+	msg = exchange(t, ws, wsRequest{Action: "subscribe", Stations: []string{"xzy-4"}})
+	if len(msg.Stations) != 1 || len(msg.Status) != 2 {
+		t.Fatalf("Expected the status of both outlets of the station, got %+v", msg)
+	}
+	msg = exchange(t, ws, wsRequest{Action: "unsubscribe", Outlets: []string{"outlet-7"}, Stations: []string{"xzy-4"}})
+	if msg.Type != "unsubscribed" || len(msg.Outlets) != 0 || len(msg.Stations) != 0 {
+		t.Fatalf("Unexpected reply %+v", msg)
+	}
+	a.cache.Set("outlet-7", cache.OutletInfo{Power: "0W", State: cache.StateFinished})
+	if msg := exchange(t, ws, wsRequest{Action: "ping"}); msg.Type != "pong" {
+		t.Errorf("Expected no change after unsubscribing, got %+v", msg)
+	}
+}
+
+func TestWebSocket_InvalidRequests(t *testing.T) {
+	a := newTestApp()
+	server := httptest.NewServer(a.routes())
+	defer server.Close()
+	defer a.events.close()
+	ws := dialWebSocket(t, server)
+
+	if msg := exchange(t, ws, wsRequest{Action: "subscribe", Outlets: []string{"missing"}}); msg.Type != "error" || !strings.Contains(msg.Error, "missing") {
+		t.Errorf("Expected an error for an unknown outlet, got %+v", msg)
+	}
+	if msg := exchange(t, ws, wsRequest{Action: "subscribe"}); msg.Type != "error" {
+		t.Errorf("Expected an error for a subscription to nothing, got %+v", msg)
+	}
+	if msg := exchange(t, ws, wsRequest{Action: "dance"}); msg.Type != "error" {
+		t.Errorf("Expected an error for an unknown action, got %+v", msg)
+	}
+	websocket.Message.Send(ws, "{")
+	if msg := receive(t, ws); msg.Type != "error" {
+		t.Errorf("Expected an error for invalid JSON, got %+v", msg)
+	}
+}
+
+func TestWebSocket_ClosedOnShutdown(t *testing.T) {
+	a := newTestApp()
+	server := httptest.NewServer(a.routes())
+	defer server.Close()
+	ws := dialWebSocket(t, server)
+	exchange(t, ws, wsRequest{Action: "ping"})
+
+	a.events.close()
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var msg wsMessage
+	if err := websocket.JSON.Receive(ws, &msg); err == nil {
+		t.Errorf("Expected the connection to close, got %+v", msg)
+	}
+}
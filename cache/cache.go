@@ -1,14 +1,52 @@
 package cache
 
+// State is the occupancy state of an outlet.
+type State string
+
+const (
+	StateUnknown State = "unknown"
+	// StateIdle means nothing is plugged in and the outlet is free to use.
+	StateIdle State = "idle"
+	// StateCharging means a vehicle is plugged in and drawing power.
+	StateCharging State = "charging"
+	// StateFinished means a session is still open but no power is drawn,
+	// typically a fully charged vehicle that is still plugged in.
+	StateFinished State = "finished"
+	// StateOffline means the upstream reports the outlet as faulty or offline.
+	StateOffline State = "offline"
+)
+
 type OutletInfo struct {
-	Power       string `json:"power"`
-	UsedMinutes int64  `json:"used_minutes"`
-	UpdatedAt   int64  `json:"updated_at"`
+	// Power is the raw billing power reported upstream, e.g. "88W".
+	Power string `json:"power"`
+	// Watts is Power parsed to a number of watts.
+	Watts       float64 `json:"watts"`
+	UsedMinutes int64   `json:"used_minutes"`
+	State       State   `json:"state"`
+	UpdatedAt   int64   `json:"updated_at"`
+	// LastSuccessAt is when the outlet was last queried successfully.
+	LastSuccessAt int64 `json:"last_success_at"`
+	// ConsecutiveFailures counts failed queries since the last success.
+	ConsecutiveFailures int    `json:"consecutive_failures"`
+	LastError           string `json:"last_error,omitempty"`
+	// Stale marks values restored from a snapshot that have not been polled since.
+	Stale bool `json:"stale,omitempty"`
 }
 
 type Cache interface {
 	Get(outletId string) (OutletInfo, bool)
+	// GetMany reads several outlets at once, leaving out those not cached.
+	GetMany(outletIds []string) map[string]OutletInfo
 	Set(outletId string, info OutletInfo)
 	JSON() []byte
+	// LoadFromJSON restores entries produced by JSON, keeping their UpdatedAt
+	// and marking them stale.
 	LoadFromJSON(data []byte) error
+	// Subscribe calls fn after every Set with what it changed, until
+	// unsubscribe is called. fn runs on the goroutine calling Set, or for a
+	// change made by another replica on the one receiving it, and must
+	// neither block nor Set. LoadFromJSON does not notify.
+	Subscribe(fn func(Change)) (unsubscribe func())
+	// Close releases the storage behind the cache.
+	Close() error
 }
@@ -0,0 +1,108 @@
+package cache
+
+import (
+	"slices"
+	"sync"
+)
+
+// EventType names one way an outlet changed between two Sets.
+type EventType string
+
+const (
+	EventStateChanged EventType = "state_changed"
+	EventPowerChanged EventType = "power_changed"
+	// The following are only emitted for outlets that were already cached,
+	// so that the first poll after startup does not report every outlet.
+	EventBecameFree  EventType = "became_free"
+	EventBecameBusy  EventType = "became_busy"
+	EventWentOffline EventType = "went_offline"
+	// EventFinishedCharging is a charging outlet that stopped drawing power,
+	// whether the vehicle is still plugged in or not.
+	EventFinishedCharging EventType = "finished_charging"
+)
+
+// EventTypes lists every EventType.
+var EventTypes = []EventType{
+	EventStateChanged, EventPowerChanged, EventBecameFree, EventBecameBusy, EventWentOffline, EventFinishedCharging,
+}
+
+// Change is what one Set did to an outlet.
+type Change struct {
+	OutletID string `json:"outlet_id"`
+	// Added is set when the outlet was not cached before; Previous is then
+	// the zero OutletInfo.
+	Added    bool       `json:"added,omitempty"`
+	Previous OutletInfo `json:"previous"`
+	Current  OutletInfo `json:"current"`
+	// Events is empty when the Set changed neither state nor power.
+	Events []EventType `json:"events,omitempty"`
+	// Remote is set for a change another replica made to a shared cache.
+	// Work that must happen once per change belongs to the replica that made
+	// it.
+	Remote bool `json:"-"`
+}
+
+func (c Change) Has(t EventType) bool {
+	return slices.Contains(c.Events, t)
+}
+
+func newChange(outletId string, previous OutletInfo, existed bool, current OutletInfo) Change {
+	c := Change{OutletID: outletId, Added: !existed, Previous: previous, Current: current}
+	if previous.State != current.State {
+		c.Events = append(c.Events, EventStateChanged)
+		if existed {
+			switch {
+			case current.State == StateIdle:
+				c.Events = append(c.Events, EventBecameFree)
+			case isBusy(current.State) && !isBusy(previous.State):
+				c.Events = append(c.Events, EventBecameBusy)
+			case current.State == StateOffline:
+				c.Events = append(c.Events, EventWentOffline)
+			}
+			if previous.State == StateCharging && (current.State == StateFinished || current.State == StateIdle) {
+				c.Events = append(c.Events, EventFinishedCharging)
+			}
+		}
+	}
+	if previous.Power != current.Power {
+		c.Events = append(c.Events, EventPowerChanged)
+	}
+	return c
+}
+
+// isBusy reports whether an outlet in state s is occupied; a finished session
+// still occupies the socket.
+func isBusy(s State) bool {
+	return s == StateCharging || s == StateFinished
+}
+
+// Notifier implements Cache.Subscribe for the caches that embed it.
+type Notifier struct {
+	mu          sync.RWMutex
+	next        int
+	subscribers map[int]func(Change)
+}
+
+func (n *Notifier) Subscribe(fn func(Change)) (unsubscribe func()) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.subscribers == nil {
+		n.subscribers = make(map[int]func(Change))
+	}
+	id := n.next
+	n.next++
+	n.subscribers[id] = fn
+	return func() {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		delete(n.subscribers, id)
+	}
+}
+
+func (n *Notifier) notify(c Change) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	for _, fn := range n.subscribers {
+		fn(c)
+	}
+}
@@ -0,0 +1,106 @@
+package cache
+
+import (
+	"charge-monitor/journal"
+	"encoding/json"
+	"log/slog"
+	"sync"
+)
+
+// FileCache is a LocalCache whose writes are journaled to a file, so its
+// content survives restarts and crashes. The journal is compacted to one
+// record per outlet once it grows past compactRatio times that size.
+type FileCache struct {
+	*LocalCache
+	journal *journal.Journal
+	// writeMu keeps journal order consistent with the order of Sets.
+	writeMu sync.Mutex
+}
+
+const compactRatio = 4
+
+type fileRecord struct {
+	ID   string     `json:"id"`
+	Info OutletInfo `json:"info"`
+}
+
+// NewFileCache opens the journal at path and restores its entries, which
+// are marked stale until they are next Set.
+func NewFileCache(path string) (*FileCache, error) {
+	local := NewLocalCache()
+	j, err := journal.Open(path, func(line []byte) error {
+		var record fileRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return err
+		}
+		record.Info.Stale = true
+		local.data[record.ID] = record.Info
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	c := &FileCache{LocalCache: local, journal: j}
+	c.compactIfNeeded()
+	return c, nil
+}
+
+func (c *FileCache) Set(outletId string, info OutletInfo) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.LocalCache.Set(outletId, info)
+	stored, _ := c.LocalCache.Get(outletId)
+	c.append(outletId, stored)
+	c.compactIfNeeded()
+}
+
+func (c *FileCache) LoadFromJSON(data []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	var jsonData map[string]OutletInfo
+	if err := json.Unmarshal(data, &jsonData); err != nil {
+		return err
+	}
+	if err := c.LocalCache.LoadFromJSON(data); err != nil {
+		return err
+	}
+	for id := range jsonData {
+		stored, _ := c.LocalCache.Get(id)
+		c.append(id, stored)
+	}
+	c.compactIfNeeded()
+	return nil
+}
+
+func (c *FileCache) append(outletId string, info OutletInfo) {
+	if err := c.journal.Append(fileRecord{ID: outletId, Info: info}); err != nil {
+		slog.Error("Failed to write cache journal", "outletId", outletId, "error", err)
+	}
+}
+
+// compactIfNeeded must be called with writeMu held.
+func (c *FileCache) compactIfNeeded() {
+	c.mu.RLock()
+	size := len(c.data)
+	c.mu.RUnlock()
+	if c.journal.Records() <= compactRatio*max(size, 16) {
+		return
+	}
+	err := c.journal.Rewrite(func(emit func(v any) error) error {
+		c.mu.RLock()
+		defer c.mu.RUnlock()
+		for id, info := range c.data {
+			if err := emit(fileRecord{ID: id, Info: info}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Error("Failed to compact cache journal", "error", err)
+	}
+}
+
+func (c *FileCache) Close() error {
+	return c.journal.Close()
+}
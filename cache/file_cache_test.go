@@ -0,0 +1,66 @@
+package cache
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileCache_SurvivesReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.jsonl")
+
+	c, err := NewFileCache(path)
+	if err != nil {
+		t.Fatalf("NewFileCache failed: %v", err)
+	}
+	c.Set("outlet-1", OutletInfo{Power: "10W", Watts: 10, State: StateCharging})
+	c.Set("outlet-1", OutletInfo{Power: "20W", Watts: 20, State: StateCharging})
+	c.Set("outlet-2", OutletInfo{State: StateIdle})
+	written, _ := c.Get("outlet-1")
+	// No Close: the journal must be readable after a crash.
+
+	reopened, err := NewFileCache(path)
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	defer reopened.Close()
+
+	info, ok := reopened.Get("outlet-1")
+	if !ok {
+		t.Fatal("Expected outlet-1 to survive reopening")
+	}
+	if info.Power != "20W" || info.UpdatedAt != written.UpdatedAt {
+		t.Errorf("Expected the latest value with its original UpdatedAt, got %+v", info)
+	}
+	if !info.Stale {
+		t.Error("Expected restored entries to be stale")
+	}
+	if info, _ := reopened.Get("outlet-2"); info.State != StateIdle {
+		t.Errorf("Expected outlet-2 to be idle, got %s", info.State)
+	}
+}
+
+func TestFileCache_Compacts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.jsonl")
+	c, err := NewFileCache(path)
+	if err != nil {
+		t.Fatalf("NewFileCache failed: %v", err)
+	}
+	defer c.Close()
+
+	for i := range 500 {
+		c.Set(fmt.Sprintf("outlet-%d", i%3), OutletInfo{UsedMinutes: int64(i)})
+	}
+	if records := c.journal.Records(); records > compactRatio*16 {
+		t.Errorf("Expected the journal to be compacted, got %d records", records)
+	}
+
+	reopened, err := NewFileCache(path)
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	defer reopened.Close()
+	if info, _ := reopened.Get("outlet-2"); info.UsedMinutes != 497 {
+		t.Errorf("Expected the latest value after compaction, got %d", info.UsedMinutes)
+	}
+}
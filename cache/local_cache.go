@@ -2,13 +2,12 @@ package cache
 
 import (
 	"encoding/json"
-	"fmt"
-	"strings"
 	"sync"
 	"time"
 )
 
 type LocalCache struct {
+	Notifier
 	data map[string]OutletInfo
 	mu   sync.RWMutex
 }
@@ -27,62 +26,54 @@ func (c *LocalCache) Get(outletId string) (OutletInfo, bool) {
 	return info, exists
 }
 
+func (c *LocalCache) GetMany(outletIds []string) map[string]OutletInfo {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	infos := make(map[string]OutletInfo, len(outletIds))
+	for _, id := range outletIds {
+		if info, ok := c.data[id]; ok {
+			infos[id] = info
+		}
+	}
+	return infos
+}
+
 func (c *LocalCache) Set(outletId string, info OutletInfo) {
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	previous, existed := c.data[outletId]
 	info.UpdatedAt = time.Now().Unix()
 	c.data[outletId] = info
+	c.mu.Unlock()
+	c.notify(newChange(outletId, previous, existed, info))
 }
 
 func (c *LocalCache) JSON() []byte {
-	// Simple JSON serialization without external libraries, using strings.Builder
 	c.mu.RLock()
 	defer c.mu.RUnlock()
-	if len(c.data) == 0 {
-		return []byte("{}")
-	}
-
-	var builder strings.Builder
-	builder.Grow(len(c.data)*80 + 2) // reduce allocations
-	builder.WriteString("{")
-	first := true
-	for id, info := range c.data {
-		if !first {
-			builder.WriteString(",")
-		}
-		builder.WriteString("\"")
-		builder.WriteString(id)
-		builder.WriteString("\":{")
-		builder.WriteString("\"power\":\"")
-		builder.WriteString(info.Power)
-		builder.WriteString("\",\"updated_at\":")
-		builder.WriteString(fmt.Sprintf("%d", info.UpdatedAt))
-		builder.WriteString(",\"used_minutes\":")
-		builder.WriteString(fmt.Sprintf("%d", info.UsedMinutes))
-		builder.WriteString("}")
-		first = false
-	}
-	builder.WriteString("}")
-	return []byte(builder.String())
+	// IDs and upstream values are arbitrary text, so let encoding/json escape
+	// them.
+	body, _ := json.Marshal(c.data)
+	return body
 }
 
 func (c *LocalCache) LoadFromJSON(data []byte) error {
-	// Simple JSON deserialization without external libraries
-	// This is a naive implementation and assumes well-formed input
-	var jsonData map[string]map[string]any
+	var jsonData map[string]OutletInfo
 	if err := json.Unmarshal(data, &jsonData); err != nil {
 		return err
 	}
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	for id, info := range jsonData {
-		outletInfo := OutletInfo{
-			Power:       info["power"].(string),
-			UsedMinutes: int64(info["used_minutes"].(float64)),
-			UpdatedAt:   int64(info["updated_at"].(float64)),
+		if info.State == "" {
+			info.State = StateUnknown
 		}
+		info.Stale = true
 		// Directly assign to preserve the original UpdatedAt timestamp
-		c.data[id] = outletInfo
+		c.data[id] = info
 	}
 	return nil
 }
+
+func (c *LocalCache) Close() error {
+	return nil
+}
@@ -3,6 +3,7 @@ package cache
 import (
 	"encoding/json"
 	"fmt"
+	"slices"
 	"sync"
 	"testing"
 	"time"
@@ -112,6 +113,17 @@ func TestCache_UpdateExisting(t *testing.T) {
 	}
 }
 
+func TestCache_GetMany(t *testing.T) {
+	c := NewLocalCache()
+	c.Set("outlet-1", OutletInfo{Power: "88W"})
+	c.Set("outlet-2", OutletInfo{Power: "20W"})
+
+	infos := c.GetMany([]string{"outlet-1", "missing"})
+	if len(infos) != 1 || infos["outlet-1"].Power != "88W" {
+		t.Errorf("Expected only outlet-1, got %+v", infos)
+	}
+}
+
 func TestCache_JSON_EmptyCache(t *testing.T) {
 	c := NewLocalCache()
 
@@ -380,3 +392,144 @@ func TestCache_ConcurrentAccess(t *testing.T) {
 		t.Error("Expected power to be set after concurrent access")
 	}
 }
+
+func TestCache_StateAndWatts_RoundTrip(t *testing.T) {
+	original := NewLocalCache()
+	original.Set("outlet-1", OutletInfo{Power: "88W", Watts: 88, UsedMinutes: 10, State: StateCharging})
+	original.Set("outlet-2", OutletInfo{State: StateIdle})
+
+	roundTrip := NewLocalCache()
+	if err := roundTrip.LoadFromJSON(original.JSON()); err != nil {
+		t.Fatalf("Round-trip deserialization failed: %v", err)
+	}
+
+	if info, _ := roundTrip.Get("outlet-1"); info.Watts != 88 {
+		t.Errorf("Expected 88 watts for outlet-1 after round-trip, got %v", info.Watts)
+	}
+
+	for id, expected := range map[string]State{"outlet-1": StateCharging, "outlet-2": StateIdle} {
+		info, _ := roundTrip.Get(id)
+		if info.State != expected {
+			t.Errorf("Expected state %s for %s after round-trip, got %s", expected, id, info.State)
+		}
+	}
+}
+
+func TestCache_LoadFromJSON_MissingState(t *testing.T) {
+	c := NewLocalCache()
+
+	err := c.LoadFromJSON([]byte(`{"outlet-1": {"power": "15W", "used_minutes": 45, "updated_at": 1695456789}}`))
+	if err != nil {
+		t.Fatalf("LoadFromJSON failed: %v", err)
+	}
+
+	info, _ := c.Get("outlet-1")
+	if info.State != StateUnknown {
+		t.Errorf("Expected state %s for data without state, got %s", StateUnknown, info.State)
+	}
+}
+
+func TestCache_FailureTracking_RoundTrip(t *testing.T) {
+	original := NewLocalCache()
+	original.Set("outlet-1", OutletInfo{
+		Power:               "88W",
+		State:               StateOffline,
+		LastSuccessAt:       1695456789,
+		ConsecutiveFailures: 3,
+		LastError:           `Get "https://example.com": timeout`,
+	})
+
+	roundTrip := NewLocalCache()
+	if err := roundTrip.LoadFromJSON(original.JSON()); err != nil {
+		t.Fatalf("Round-trip deserialization failed: %v", err)
+	}
+
+	info, _ := roundTrip.Get("outlet-1")
+	if info.LastSuccessAt != 1695456789 {
+		t.Errorf("Expected LastSuccessAt 1695456789, got %d", info.LastSuccessAt)
+	}
+	if info.ConsecutiveFailures != 3 {
+		t.Errorf("Expected 3 consecutive failures, got %d", info.ConsecutiveFailures)
+	}
+	if info.LastError != `Get "https://example.com": timeout` {
+		t.Errorf("Expected last error to survive escaping, got %q", info.LastError)
+	}
+}
+
+func TestCache_JSON_EscapesIDsAndPower(t *testing.T) {
+	original := NewLocalCache()
+	original.Set(`outlet-"1"`, OutletInfo{Power: `8"8W\`, State: StateCharging})
+
+	restored := NewLocalCache()
+	if err := restored.LoadFromJSON(original.JSON()); err != nil {
+		t.Fatalf("Expected valid JSON, got %v: %s", err, original.JSON())
+	}
+	if info, ok := restored.Get(`outlet-"1"`); !ok || info.Power != `8"8W\` {
+		t.Errorf("Expected the ID and power to survive escaping, got %+v, %v", info, ok)
+	}
+}
+
+func TestCache_LoadFromJSON_MarksStale(t *testing.T) {
+	original := NewLocalCache()
+	original.Set("outlet-1", OutletInfo{Power: "88W", State: StateCharging})
+	if info, _ := original.Get("outlet-1"); info.Stale {
+		t.Fatal("Expected freshly set entry not to be stale")
+	}
+
+	restored := NewLocalCache()
+	if err := restored.LoadFromJSON(original.JSON()); err != nil {
+		t.Fatalf("LoadFromJSON failed: %v", err)
+	}
+	info, _ := restored.Get("outlet-1")
+	if !info.Stale {
+		t.Error("Expected restored entry to be stale")
+	}
+
+	restored.Set("outlet-1", OutletInfo{Power: "90W", State: StateCharging})
+	if info, _ := restored.Get("outlet-1"); info.Stale {
+		t.Error("Expected entry to be fresh again after Set")
+	}
+}
+
+func TestLocalCache_Subscribe(t *testing.T) {
+	c := NewLocalCache()
+	var changes []Change
+	unsubscribe := c.Subscribe(func(change Change) { changes = append(changes, change) })
+
+	c.Set("outlet-1", OutletInfo{Power: "0W", State: StateIdle})
+	c.Set("outlet-1", OutletInfo{Power: "0W", State: StateIdle, UsedMinutes: 1})
+	c.Set("outlet-1", OutletInfo{Power: "88W", State: StateCharging})
+	c.Set("outlet-1", OutletInfo{Power: "0W", State: StateFinished})
+	c.Set("outlet-1", OutletInfo{Power: "0W", State: StateOffline})
+	c.Set("outlet-1", OutletInfo{Power: "0W", State: StateIdle})
+
+	expected := [][]EventType{
+		{EventStateChanged, EventPowerChanged},
+		nil,
+		{EventStateChanged, EventBecameBusy, EventPowerChanged},
+		{EventStateChanged, EventFinishedCharging, EventPowerChanged},
+		{EventStateChanged, EventWentOffline},
+		{EventStateChanged, EventBecameFree},
+	}
+	if len(changes) != len(expected) {
+		t.Fatalf("Expected %d changes, got %d", len(expected), len(changes))
+	}
+	for i, events := range expected {
+		if !slices.Equal(changes[i].Events, events) {
+			t.Errorf("Change %d: expected events %v, got %v", i, events, changes[i].Events)
+		}
+	}
+	if !changes[0].Added || changes[1].Added {
+		t.Error("Expected only the first change to be an addition")
+	}
+	if changes[2].Previous.State != StateIdle || changes[2].Current.UpdatedAt == 0 {
+		t.Errorf("Unexpected change %+v", changes[2])
+	}
+
+	c.LoadFromJSON([]byte(`{"outlet-2":{"power":"10W","state":"charging"}}`))
+	unsubscribe()
+	c.Set("outlet-1", OutletInfo{Power: "88W", State: StateCharging})
+	if len(changes) != len(expected) {
+		t.Errorf("Expected no notification after LoadFromJSON or unsubscribing, got %d changes", len(changes))
+	}
+}
@@ -0,0 +1,253 @@
+package cache
+
+import (
+	"charge-monitor/redis"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RedisCache stores each outlet under its own key in a Redis-compatible
+// server, so several replicas share one view. Keys expire after ttl so
+// outlets that are no longer polled disappear. Changes are published on the
+// channel prefix+"changes", so the subscribers of every replica hear about
+// them.
+type RedisCache struct {
+	Notifier
+	client  *redis.Client
+	prefix  string
+	ttl     time.Duration
+	timeout time.Duration
+	// origin tells the changes of this instance apart from other replicas'.
+	origin string
+	stop   context.CancelFunc
+	done   sync.WaitGroup
+}
+
+// published is a change as sent to the other replicas.
+type published struct {
+	Origin string `json:"origin"`
+	Change Change `json:"change"`
+}
+
+// retryInterval is how long to wait before subscribing again after the
+// subscription failed.
+const retryInterval = time.Second
+
+func NewRedisCache(client *redis.Client, prefix string, ttl time.Duration) *RedisCache {
+	origin := make([]byte, 8)
+	rand.Read(origin)
+	ctx, stop := context.WithCancel(context.Background())
+	c := &RedisCache{
+		client:  client,
+		prefix:  prefix,
+		ttl:     ttl,
+		timeout: 5 * time.Second,
+		origin:  hex.EncodeToString(origin),
+		stop:    stop,
+	}
+	c.done.Go(func() { c.listen(ctx) })
+	return c
+}
+
+// listen notifies subscribers of the changes of other replicas until ctx is
+// cancelled.
+func (c *RedisCache) listen(ctx context.Context) {
+	for {
+		err := c.client.Subscribe(ctx, c.prefix+"changes", c.receive)
+		if ctx.Err() != nil {
+			return
+		}
+		slog.Error("Lost redis change subscription", "error", err)
+		select {
+		case <-time.After(retryInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (c *RedisCache) receive(message string) {
+	var p published
+	if err := json.Unmarshal([]byte(message), &p); err != nil {
+		slog.Error("Failed to decode change from redis", "error", err)
+		return
+	}
+	if p.Origin == c.origin {
+		return
+	}
+	p.Change.Remote = true
+	c.notify(p.Change)
+}
+
+func (c *RedisCache) Get(outletId string) (OutletInfo, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	value, ok, err := c.client.String(ctx, "GET", c.prefix+outletId)
+	if err != nil {
+		slog.Error("Failed to read outlet from redis", "outletId", outletId, "error", err)
+		return OutletInfo{}, false
+	}
+	if !ok {
+		return OutletInfo{}, false
+	}
+	var info OutletInfo
+	if err := json.Unmarshal([]byte(value), &info); err != nil {
+		slog.Error("Failed to decode outlet from redis", "outletId", outletId, "error", err)
+		return OutletInfo{}, false
+	}
+	return info, true
+}
+
+// GetMany fetches the outlets with a single MGET.
+func (c *RedisCache) GetMany(outletIds []string) map[string]OutletInfo {
+	infos := make(map[string]OutletInfo, len(outletIds))
+	if len(outletIds) == 0 {
+		return infos
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	keys := make([]string, len(outletIds))
+	for i, id := range outletIds {
+		keys[i] = c.prefix + id
+	}
+	if err := c.mget(ctx, keys, infos); err != nil {
+		slog.Error("Failed to read outlets from redis", "outlets", len(outletIds), "error", err)
+	}
+	return infos
+}
+
+// Set notifies the subscribers of this instance at once and publishes the
+// change to the other replicas.
+func (c *RedisCache) Set(outletId string, info OutletInfo) {
+	previous, existed := c.Get(outletId)
+	info.UpdatedAt = time.Now().Unix()
+	if err := c.set(outletId, info); err != nil {
+		slog.Error("Failed to write outlet to redis", "outletId", outletId, "error", err)
+		return
+	}
+	change := newChange(outletId, previous, existed, info)
+	c.notify(change)
+	if err := c.publish(change); err != nil {
+		slog.Error("Failed to publish change to redis", "outletId", outletId, "error", err)
+	}
+}
+
+func (c *RedisCache) publish(change Change) error {
+	message, err := json.Marshal(published{Origin: c.origin, Change: change})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	_, err = c.client.Do(ctx, "PUBLISH", c.prefix+"changes", string(message))
+	return err
+}
+
+func (c *RedisCache) set(outletId string, info OutletInfo) error {
+	value, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	args := []string{"SET", c.prefix + outletId, string(value)}
+	if c.ttl > 0 {
+		args = append(args, "PX", strconv.FormatInt(c.ttl.Milliseconds(), 10))
+	}
+	_, err = c.client.Do(ctx, args...)
+	return err
+}
+
+func (c *RedisCache) JSON() []byte {
+	data, err := c.all()
+	if err != nil {
+		slog.Error("Failed to read outlets from redis", "error", err)
+	}
+	body, _ := json.Marshal(data)
+	return body
+}
+
+// all scans the keys under the prefix and fetches them in batches.
+func (c *RedisCache) all() (map[string]OutletInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	data := make(map[string]OutletInfo)
+	cursor := "0"
+	for {
+		reply, err := c.client.Do(ctx, "SCAN", cursor, "MATCH", c.prefix+"*", "COUNT", "500")
+		if err != nil {
+			return data, err
+		}
+		page, _ := reply.([]any)
+		if len(page) != 2 {
+			return data, redis.Error("unexpected SCAN reply")
+		}
+		cursor, _ = page[0].(string)
+		page, _ = page[1].([]any)
+		keys := make([]string, 0, len(page))
+		for _, key := range page {
+			keys = append(keys, key.(string))
+		}
+		if err := c.mget(ctx, keys, data); err != nil {
+			return data, err
+		}
+		if cursor == "0" {
+			return data, nil
+		}
+	}
+}
+
+// mget reads keys into data by outlet ID, skipping missing keys.
+func (c *RedisCache) mget(ctx context.Context, keys []string, data map[string]OutletInfo) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	reply, err := c.client.Do(ctx, append([]string{"MGET"}, keys...)...)
+	if err != nil {
+		return err
+	}
+	values, _ := reply.([]any)
+	for i, value := range values {
+		s, ok := value.(string)
+		if !ok {
+			// Missing, or expired since it was listed.
+			continue
+		}
+		var info OutletInfo
+		if err := json.Unmarshal([]byte(s), &info); err != nil {
+			continue
+		}
+		data[strings.TrimPrefix(keys[i], c.prefix)] = info
+	}
+	return nil
+}
+
+func (c *RedisCache) LoadFromJSON(data []byte) error {
+	var jsonData map[string]OutletInfo
+	if err := json.Unmarshal(data, &jsonData); err != nil {
+		return err
+	}
+	for id, info := range jsonData {
+		if info.State == "" {
+			info.State = StateUnknown
+		}
+		info.Stale = true
+		if err := c.set(id, info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *RedisCache) Close() error {
+	c.stop()
+	c.done.Wait()
+	return c.client.Close()
+}
@@ -0,0 +1,151 @@
+package cache
+
+import (
+	"charge-monitor/redis"
+	"charge-monitor/redis/redistest"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func newTestRedisCache(t *testing.T, ttl time.Duration) (*RedisCache, *redistest.Server) {
+	server, err := redistest.NewServer("")
+	if err != nil {
+		t.Fatalf("Failed to start redis server: %v", err)
+	}
+	t.Cleanup(server.Close)
+	c := NewRedisCache(redis.NewClient(redis.Options{Address: server.Addr()}), "charge-monitor:outlet:", ttl)
+	t.Cleanup(func() { c.Close() })
+	return c, server
+}
+
+func TestRedisCache_SetAndGet(t *testing.T) {
+	c, _ := newTestRedisCache(t, time.Minute)
+
+	c.Set("outlet-1", OutletInfo{Power: "88W", Watts: 88, UsedMinutes: 10, State: StateCharging})
+	info, ok := c.Get("outlet-1")
+	if !ok {
+		t.Fatal("Expected outlet to exist in cache")
+	}
+	if info.Power != "88W" || info.Watts != 88 || info.State != StateCharging {
+		t.Errorf("Unexpected info %+v", info)
+	}
+	if info.UpdatedAt == 0 {
+		t.Error("Expected UpdatedAt to be set")
+	}
+	if _, ok := c.Get("missing"); ok {
+		t.Error("Expected missing outlet not to exist")
+	}
+}
+
+func TestRedisCache_SharedBetweenReplicas(t *testing.T) {
+	c, server := newTestRedisCache(t, time.Minute)
+	replica := NewRedisCache(redis.NewClient(redis.Options{Address: server.Addr()}), "charge-monitor:outlet:", time.Minute)
+	defer replica.Close()
+
+	c.Set("outlet-1", OutletInfo{Power: "88W", State: StateCharging})
+	if info, ok := replica.Get("outlet-1"); !ok || info.Power != "88W" {
+		t.Errorf("Expected the replica to see the write, got %+v", info)
+	}
+}
+
+func TestRedisCache_GetMany(t *testing.T) {
+	c, _ := newTestRedisCache(t, time.Minute)
+	c.Set("outlet-1", OutletInfo{Power: "88W", State: StateCharging})
+	c.Set("outlet-7", OutletInfo{Power: "0W", State: StateIdle})
+
+	infos := c.GetMany([]string{"outlet-1", "missing", "outlet-7"})
+	if len(infos) != 2 || infos["outlet-1"].Power != "88W" || infos["outlet-7"].State != StateIdle {
+		t.Errorf("Expected the two cached outlets, got %+v", infos)
+	}
+	if infos := c.GetMany(nil); len(infos) != 0 {
+		t.Errorf("Expected no outlets, got %+v", infos)
+	}
+}
+
+func TestRedisCache_TTL(t *testing.T) {
+	c, server := newTestRedisCache(t, time.Minute)
+
+	c.Set("outlet-1", OutletInfo{Power: "88W"})
+	server.FastForward(2 * time.Minute)
+	if _, ok := c.Get("outlet-1"); ok {
+		t.Error("Expected the outlet to expire after the TTL")
+	}
+}
+
+func TestRedisCache_JSON_RoundTrip(t *testing.T) {
+	c, _ := newTestRedisCache(t, time.Minute)
+	c.Set("outlet-1", OutletInfo{Power: "10W", UsedMinutes: 1})
+	c.Set("outlet-2", OutletInfo{Power: "20W", UsedMinutes: 2})
+
+	var parsed map[string]OutletInfo
+	if err := json.Unmarshal(c.JSON(), &parsed); err != nil {
+		t.Fatalf("Generated JSON is not valid: %v", err)
+	}
+	if len(parsed) != 2 || parsed["outlet-2"].Power != "20W" {
+		t.Errorf("Unexpected JSON content %+v", parsed)
+	}
+
+	other, _ := newTestRedisCache(t, time.Minute)
+	if err := other.LoadFromJSON(c.JSON()); err != nil {
+		t.Fatalf("LoadFromJSON failed: %v", err)
+	}
+	info, ok := other.Get("outlet-1")
+	if !ok || info.UsedMinutes != 1 || !info.Stale || info.UpdatedAt != parsed["outlet-1"].UpdatedAt {
+		t.Errorf("Expected a stale copy with the original UpdatedAt, got %+v", info)
+	}
+}
+
+func TestRedisCache_EmptyJSON(t *testing.T) {
+	c, _ := newTestRedisCache(t, time.Minute)
+	if body := string(c.JSON()); body != "{}" {
+		t.Errorf("Expected empty cache JSON to be {}, got %s", body)
+	}
+}
+
+func TestRedisCache_Subscribe(t *testing.T) {
+	c, _ := newTestRedisCache(t, time.Minute)
+	var changes []Change
+	c.Subscribe(func(change Change) { changes = append(changes, change) })
+
+	c.Set("outlet-1", OutletInfo{Power: "88W", State: StateCharging})
+	c.Set("outlet-1", OutletInfo{Power: "0W", State: StateIdle})
+	if len(changes) != 2 || !changes[0].Added {
+		t.Fatalf("Expected 2 changes starting with an addition, got %+v", changes)
+	}
+	if !changes[1].Has(EventBecameFree) || changes[1].Previous.Power != "88W" {
+		t.Errorf("Expected the outlet to become free, got %+v", changes[1])
+	}
+}
+
+func TestRedisCache_NotifiesReplicas(t *testing.T) {
+	c, server := newTestRedisCache(t, time.Minute)
+	replica := NewRedisCache(redis.NewClient(redis.Options{Address: server.Addr()}), "charge-monitor:outlet:", time.Minute)
+	defer replica.Close()
+	local := make(chan Change, 10)
+	c.Subscribe(func(change Change) { local <- change })
+	remote := make(chan Change, 10)
+	replica.Subscribe(func(change Change) { remote <- change })
+
+	// Set until the replica has subscribed, since earlier changes are lost.
+	deadline := time.After(2 * time.Second)
+	var change Change
+	for received := false; !received; {
+		c.Set("outlet-1", OutletInfo{Power: "88W", State: StateCharging})
+		select {
+		case change = <-remote:
+			received = true
+		case <-time.After(10 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("Timed out waiting for the replica to hear about the change")
+		}
+	}
+	if !change.Remote || change.OutletID != "outlet-1" || change.Current.Power != "88W" {
+		t.Errorf("Unexpected remote change %+v", change)
+	}
+	for len(local) > 0 {
+		if change := <-local; change.Remote {
+			t.Error("Expected the instance not to hear its own changes as remote")
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package config
+
+// Catalog indexes the configured stations and outlets for lookups by ID.
+type Catalog struct {
+	Stations []Station
+	// Ungrouped holds outlets from the legacy flat list.
+	Ungrouped []Outlet
+
+	ids      []string
+	stations map[string]*Station
+	outlets  map[string]OutletRef
+}
+
+// OutletRef is an outlet together with the station it belongs to, if any.
+type OutletRef struct {
+	Outlet
+	Station *Station
+}
+
+// Provider returns the name of the provider that serves the outlet.
+func (r OutletRef) Provider() string {
+	if r.Station == nil || r.Station.Provider == "" {
+		return DefaultProvider
+	}
+	return r.Station.Provider
+}
+
+func NewCatalog(c *Config) *Catalog {
+	catalog := &Catalog{
+		Stations: c.Stations,
+		ids:      c.OutletIDs(),
+		stations: make(map[string]*Station),
+		outlets:  make(map[string]OutletRef),
+	}
+	for i := range catalog.Stations {
+		station := &catalog.Stations[i]
+		catalog.stations[station.ID] = station
+		for _, outlet := range station.Outlets {
+			catalog.outlets[outlet.ID] = OutletRef{Outlet: outlet, Station: station}
+		}
+	}
+	for _, id := range c.Outlets {
+		outlet := Outlet{ID: id}
+		catalog.Ungrouped = append(catalog.Ungrouped, outlet)
+		catalog.outlets[id] = OutletRef{Outlet: outlet}
+	}
+	return catalog
+}
+
+func (c *Catalog) Station(id string) (*Station, bool) {
+	station, ok := c.stations[id]
+	return station, ok
+}
+
+func (c *Catalog) Outlet(id string) (OutletRef, bool) {
+	ref, ok := c.outlets[id]
+	return ref, ok
+}
+
+// OutletIDs returns the IDs of every outlet in the catalog.
+func (c *Catalog) OutletIDs() []string {
+	return c.ids
+}
@@ -1,16 +1,288 @@
 package config
 
 import (
+	"fmt"
 	"log/slog"
+	"net/netip"
+	"net/url"
+	"strings"
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/spf13/viper"
 )
 
+type Outlet struct {
+	ID     string `mapstructure:"id" json:"id"`
+	Socket int    `mapstructure:"socket" json:"socket,omitempty"`
+	Label  string `mapstructure:"label" json:"label,omitempty"`
+}
+
+type Station struct {
+	ID        string  `mapstructure:"id" json:"id"`
+	Name      string  `mapstructure:"name" json:"name"`
+	Campus    string  `mapstructure:"campus" json:"campus,omitempty"`
+	Area      string  `mapstructure:"area" json:"area,omitempty"`
+	Latitude  float64 `mapstructure:"latitude" json:"latitude,omitempty"`
+	Longitude float64 `mapstructure:"longitude" json:"longitude,omitempty"`
+	// Provider names the entry in Config.Providers that serves this station;
+	// empty selects DefaultProvider.
+	Provider string `mapstructure:"provider" json:"provider,omitempty"`
+	// Tariff names the entry in Config.Tariffs that prices charging here;
+	// empty selects DefaultTariff.
+	Tariff  string   `mapstructure:"tariff" json:"tariff,omitempty"`
+	Outlets []Outlet `mapstructure:"outlets" json:"outlets"`
+}
+
+// DefaultProvider is the name of the provider configured by the top-level
+// upstream section.
+const DefaultProvider = "default"
+
+type UpstreamConfig struct {
+	// Type selects the provider implementation; defaults to "wemp".
+	Type string `mapstructure:"type"`
+	// BaseURL defaults to the wemp.issks.com API.
+	BaseURL string `mapstructure:"base_url"`
+	// Timeout is the per-request timeout in milliseconds; defaults to 10000.
+	Timeout   int64  `mapstructure:"timeout"`
+	UserAgent string `mapstructure:"user_agent"`
+	// Proxy is an optional HTTP(S) proxy URL for upstream requests.
+	Proxy string `mapstructure:"proxy"`
+}
+
+type RetryConfig struct {
+	// Attempts is the number of retries after a failed query.
+	Attempts int `mapstructure:"attempts"`
+	// BaseDelay and MaxDelay bound the exponential backoff, in milliseconds.
+	BaseDelay int64 `mapstructure:"base_delay"`
+	MaxDelay  int64 `mapstructure:"max_delay"`
+}
+
+type CircuitBreakerConfig struct {
+	// FailureThreshold is the number of consecutive upstream failures, across
+	// all outlets, that opens the breaker. Zero disables it.
+	FailureThreshold int `mapstructure:"failure_threshold"`
+	// Cooldown is how long the breaker stays open before probing, in milliseconds.
+	Cooldown int64 `mapstructure:"cooldown"`
+}
+
+type SnapshotConfig struct {
+	// Path is the file the cache is persisted to; empty disables snapshots.
+	Path string `mapstructure:"path"`
+	// Interval between snapshots in milliseconds.
+	Interval int64 `mapstructure:"interval"`
+}
+
+type HistoryConfig struct {
+	// Retention is how long samples are kept, in milliseconds.
+	Retention int64 `mapstructure:"retention"`
+}
+
+// DefaultTariff is the name of the tariff of stations that do not name one,
+// and of outlets outside any station.
+const DefaultTariff = "default"
+
+type TariffTierConfig struct {
+	// MaxWatts is the inclusive upper bound of the power band; 0 leaves it open.
+	MaxWatts float64 `mapstructure:"max_watts"`
+	PerHour  float64 `mapstructure:"per_hour"`
+	PerKWh   float64 `mapstructure:"per_kwh"`
+}
+
+type TariffPeriodConfig struct {
+	// Start is the local time of day the period begins, as "HH:MM". A period
+	// lasts until the next one starts.
+	Start string             `mapstructure:"start"`
+	Tiers []TariffTierConfig `mapstructure:"tiers"`
+}
+
+type TariffConfig struct {
+	Currency string `mapstructure:"currency"`
+	// TimeZone is the IANA time zone of the periods; empty uses the local one.
+	TimeZone string               `mapstructure:"time_zone"`
+	Periods  []TariffPeriodConfig `mapstructure:"periods"`
+	// Tiers is shorthand for a single period lasting all day.
+	Tiers []TariffTierConfig `mapstructure:"tiers"`
+}
+
+type SessionsConfig struct {
+	// Retention is how long finished sessions are kept, in milliseconds.
+	Retention int64 `mapstructure:"retention"`
+}
+
+type AnalyticsConfig struct {
+	// MaxGap is how long, in milliseconds, a sample counts towards
+	// utilization and energy when no newer one follows, as when polling
+	// stopped.
+	MaxGap int64 `mapstructure:"max_gap"`
+}
+
+const (
+	StorageMemory = "memory"
+	StorageFile   = "file"
+	StorageRedis  = "redis"
+)
+
+type StorageConfig struct {
+	// Backend is StorageMemory (the default), StorageFile or StorageRedis.
+	Backend string `mapstructure:"backend"`
+	// Dir holds the files of the file backend.
+	Dir   string      `mapstructure:"dir"`
+	Redis RedisConfig `mapstructure:"redis"`
+}
+
+type RedisConfig struct {
+	Address  string `mapstructure:"address"`
+	Password string `mapstructure:"password"`
+	DB       int    `mapstructure:"db"`
+	// Prefix is prepended to every key; defaults to "charge-monitor:".
+	Prefix string `mapstructure:"prefix"`
+	// TTL is how long an outlet's status is kept after its last update, in
+	// milliseconds. Zero keeps it forever.
+	TTL int64 `mapstructure:"ttl"`
+}
+
+const (
+	ElectionFile  = "file"
+	ElectionRedis = "redis"
+)
+
+type LeaderElectionConfig struct {
+	// Backend is empty to always poll, ElectionFile to hold a lock on Path,
+	// or ElectionRedis to hold a lease in the server of storage.redis.
+	Backend string `mapstructure:"backend"`
+	Path    string `mapstructure:"path"`
+	// Lease is how long a leader that stops renewing keeps leadership, in
+	// milliseconds. Instances campaign three times per lease.
+	Lease int64 `mapstructure:"lease"`
+}
+
+type WebhookConfig struct {
+	ID     string `mapstructure:"id"`
+	URL    string `mapstructure:"url"`
+	Secret string `mapstructure:"secret"`
+	// Events defaults to became_free and finished_charging.
+	Events   []string `mapstructure:"events"`
+	Outlets  []string `mapstructure:"outlets"`
+	Stations []string `mapstructure:"stations"`
+}
+
+type WebhooksConfig struct {
+	Subscriptions []WebhookConfig `mapstructure:"subscriptions"`
+	// Timeout bounds each delivery attempt, in milliseconds.
+	Timeout int64       `mapstructure:"timeout"`
+	Retry   RetryConfig `mapstructure:"retry"`
+	// DeadLetter is the file failed deliveries are appended to.
+	DeadLetter string `mapstructure:"dead_letter"`
+	// Store is the file webhooks registered through the admin API are kept in.
+	Store string `mapstructure:"store"`
+}
+
+type NotificationsConfig struct {
+	// Webhook enables the "webhook" channel, which POSTs a JSON message to a
+	// URL chosen by the user.
+	Webhook WebhookChannelConfig `mapstructure:"webhook"`
+	// SMTP enables the "email" channel when Host is set.
+	SMTP SMTPConfig `mapstructure:"smtp"`
+	// ChatBot enables the "chatbot" channel when URL is set.
+	ChatBot ChatBotConfig `mapstructure:"chatbot"`
+}
+
+type WebhookChannelConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Timeout bounds each request, in milliseconds.
+	Timeout int64 `mapstructure:"timeout"`
+	// AllowPrivate lets targets resolve to loopback, private and link-local
+	// addresses, which are refused by default.
+	AllowPrivate bool `mapstructure:"allow_private"`
+}
+
+type SMTPConfig struct {
+	Host     string `mapstructure:"host"`
+	Port     int    `mapstructure:"port"`
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+	From     string `mapstructure:"from"`
+	// Timeout bounds sending one message, in milliseconds.
+	Timeout int64 `mapstructure:"timeout"`
+}
+
+type ChatBotConfig struct {
+	// URL is the bot endpoint messages are POSTed to.
+	URL string `mapstructure:"url"`
+	// Token is sent as a bearer token when set.
+	Token string `mapstructure:"token"`
+	// Timeout bounds each request, in milliseconds.
+	Timeout int64 `mapstructure:"timeout"`
+}
+
+type WatchesConfig struct {
+	// DefaultTTL and MaxTTL bound how long a watch waits, in milliseconds.
+	DefaultTTL int64 `mapstructure:"default_ttl"`
+	MaxTTL     int64 `mapstructure:"max_ttl"`
+	// Limit caps the number of watches held at once.
+	Limit int `mapstructure:"limit"`
+	// ClientLimit caps the watches one client address may create per hour.
+	ClientLimit int `mapstructure:"client_limit"`
+	// StallAfter is how long the used minutes of a charging outlet must stay
+	// the same for its session to count as finished, in milliseconds.
+	StallAfter int64 `mapstructure:"stall_after"`
+}
+
 type Config struct {
-	Outlets         []string `mapstructure:"outlets"`
-	PollingInterval int64    `mapstructure:"polling_interval"`
-	HTTPAddress     string   `mapstructure:"http_address"`
+	Stations []Station `mapstructure:"stations"`
+	// Outlets is the legacy flat list of outlet IDs that belong to no station.
+	Outlets []string `mapstructure:"outlets"`
+	// PollingInterval is the gap between upstream requests in milliseconds,
+	// used when PollingRateLimit is not set.
+	PollingInterval int64 `mapstructure:"polling_interval"`
+	// PollingConcurrency is the number of outlets queried in parallel.
+	PollingConcurrency int `mapstructure:"polling_concurrency"`
+	// PollingRateLimit caps upstream requests per second across all workers.
+	PollingRateLimit float64              `mapstructure:"polling_rate_limit"`
+	Retry            RetryConfig          `mapstructure:"retry"`
+	CircuitBreaker   CircuitBreakerConfig `mapstructure:"circuit_breaker"`
+	// OfflineAfter is the number of consecutive failures after which an
+	// outlet is reported offline.
+	OfflineAfter int `mapstructure:"offline_after"`
+	// DisablePolling makes the instance serve HTTP only, for replicas that
+	// read the state another instance writes to a shared storage backend.
+	DisablePolling bool `mapstructure:"disable_polling"`
+	// LeaderElection lets replicas agree on a single one that polls.
+	LeaderElection LeaderElectionConfig `mapstructure:"leader_election"`
+	HTTPAddress    string               `mapstructure:"http_address"`
+	Storage        StorageConfig        `mapstructure:"storage"`
+	Snapshot       SnapshotConfig       `mapstructure:"snapshot"`
+	History        HistoryConfig        `mapstructure:"history"`
+	Sessions       SessionsConfig       `mapstructure:"sessions"`
+	Analytics      AnalyticsConfig      `mapstructure:"analytics"`
+	// ShutdownTimeout bounds how long shutdown waits for in-flight requests,
+	// in milliseconds.
+	ShutdownTimeout int64          `mapstructure:"shutdown_timeout"`
+	Upstream        UpstreamConfig `mapstructure:"upstream"`
+	// Providers holds additional named upstreams that stations can refer to.
+	// Names are case-insensitive.
+	Providers     map[string]UpstreamConfig `mapstructure:"providers"`
+	Webhooks      WebhooksConfig            `mapstructure:"webhooks"`
+	Notifications NotificationsConfig       `mapstructure:"notifications"`
+	Watches       WatchesConfig             `mapstructure:"watches"`
+	// Tariffs holds named tariffs that stations can refer to. Names are
+	// case-insensitive.
+	Tariffs map[string]TariffConfig `mapstructure:"tariffs"`
+	// AdminToken is the bearer token of the admin API; empty disables it.
+	AdminToken string `mapstructure:"admin_token"`
+	// TrustedProxies lists the addresses or CIDR ranges of load balancers
+	// whose X-Forwarded-For or X-Real-IP header names the client.
+	TrustedProxies []string `mapstructure:"trusted_proxies"`
+}
+
+// AllProviders returns every configured provider by name, including the
+// default one.
+func (c *Config) AllProviders() map[string]UpstreamConfig {
+	providers := map[string]UpstreamConfig{DefaultProvider: c.Upstream}
+	for name, provider := range c.Providers {
+		providers[name] = provider
+	}
+	return providers
 }
 
 func ConfigFromFile() (*Config, error) {
@@ -18,23 +290,158 @@ func ConfigFromFile() (*Config, error) {
 	if err := viper.ReadInConfig(); err != nil {
 		return nil, err
 	}
-	var conf Config
-	if err := viper.Unmarshal(&conf); err != nil {
+	conf, err := unmarshal()
+	if err != nil {
 		return nil, err
 	}
-	slog.Info("Outlets loaded", "count", len(conf.Outlets))
-	return &conf, nil
+	slog.Info("Outlets loaded", "stations", len(conf.Stations), "count", len(conf.OutletIDs()))
+	return conf, nil
 }
 
-func (c *Config) LiveReload(onChange func([]string)) {
+func (c *Config) LiveReload(onChange func(*Config)) {
 	viper.WatchConfig()
 	viper.OnConfigChange(func(e fsnotify.Event) {
 		slog.Info("Config file changed", "file", e.Name)
-		if err := viper.Unmarshal(c); err != nil {
+		conf, err := unmarshal()
+		if err != nil {
 			slog.Error("Failed to reload config", "error", err)
-		} else {
-			slog.Info("Config reloaded successfully", "outlets", len(c.Outlets))
-			onChange(c.Outlets)
+			return
 		}
+		*c = *conf
+		slog.Info("Config reloaded successfully", "stations", len(c.Stations), "outlets", len(c.OutletIDs()))
+		onChange(c)
 	})
 }
+
+// OutletIDs returns the IDs of the station's outlets.
+func (s *Station) OutletIDs() []string {
+	ids := make([]string, len(s.Outlets))
+	for i, outlet := range s.Outlets {
+		ids[i] = outlet.ID
+	}
+	return ids
+}
+
+// OutletIDs returns the IDs of every configured outlet, station outlets first.
+func (c *Config) OutletIDs() []string {
+	var ids []string
+	for i := range c.Stations {
+		ids = append(ids, c.Stations[i].OutletIDs()...)
+	}
+	return append(ids, c.Outlets...)
+}
+
+// TrustedProxyPrefixes returns TrustedProxies as prefixes, a bare address
+// being a prefix of its own. Invalid entries are skipped; validate reports
+// them.
+func (c *Config) TrustedProxyPrefixes() []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, proxy := range c.TrustedProxies {
+		if prefix, err := parsePrefix(proxy); err == nil {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	return prefixes
+}
+
+func parsePrefix(s string) (netip.Prefix, error) {
+	if addr, err := netip.ParseAddr(s); err == nil {
+		return netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return prefix.Masked(), nil
+}
+
+func unmarshal() (*Config, error) {
+	var conf Config
+	if err := viper.Unmarshal(&conf); err != nil {
+		return nil, err
+	}
+	// viper lowercases map keys, so provider references must follow suit.
+	for i := range conf.Stations {
+		conf.Stations[i].Provider = strings.ToLower(conf.Stations[i].Provider)
+		conf.Stations[i].Tariff = strings.ToLower(conf.Stations[i].Tariff)
+	}
+	if err := conf.validate(); err != nil {
+		return nil, err
+	}
+	return &conf, nil
+}
+
+func (c *Config) validate() error {
+	switch c.Storage.Backend {
+	case "", StorageMemory, StorageFile:
+	case StorageRedis:
+		if c.Storage.Redis.Address == "" {
+			return fmt.Errorf("storage backend %q needs an address", StorageRedis)
+		}
+	default:
+		return fmt.Errorf("unknown storage backend %q", c.Storage.Backend)
+	}
+	switch c.LeaderElection.Backend {
+	case "", ElectionFile:
+	case ElectionRedis:
+		if c.Storage.Redis.Address == "" {
+			return fmt.Errorf("leader election backend %q needs storage.redis.address", ElectionRedis)
+		}
+	default:
+		return fmt.Errorf("unknown leader election backend %q", c.LeaderElection.Backend)
+	}
+	if c.LeaderElection.Backend != "" && c.Storage.Backend != StorageRedis {
+		// Standbys would serve a cache that nothing fills.
+		return fmt.Errorf("leader election needs storage backend %q", StorageRedis)
+	}
+	for _, proxy := range c.TrustedProxies {
+		if _, err := parsePrefix(proxy); err != nil {
+			return fmt.Errorf("invalid trusted proxy %q: %w", proxy, err)
+		}
+	}
+	if _, ok := c.Providers[DefaultProvider]; ok {
+		// It would silently replace upstream.
+		return fmt.Errorf("provider name %q is reserved for upstream", DefaultProvider)
+	}
+	providers := c.AllProviders()
+	for name, provider := range providers {
+		if provider.Proxy != "" {
+			if _, err := url.Parse(provider.Proxy); err != nil {
+				return fmt.Errorf("invalid proxy for provider %q: %w", name, err)
+			}
+		}
+	}
+	stations := make(map[string]bool)
+	outlets := make(map[string]bool)
+	for _, station := range c.Stations {
+		if station.ID == "" {
+			return fmt.Errorf("station %q has no id", station.Name)
+		}
+		if stations[station.ID] {
+			return fmt.Errorf("duplicate station id %q", station.ID)
+		}
+		stations[station.ID] = true
+		if _, ok := providers[station.Provider]; station.Provider != "" && !ok {
+			return fmt.Errorf("station %q refers to unknown provider %q", station.ID, station.Provider)
+		}
+		if _, ok := c.Tariffs[station.Tariff]; station.Tariff != "" && !ok {
+			return fmt.Errorf("station %q refers to unknown tariff %q", station.ID, station.Tariff)
+		}
+		for _, outlet := range station.Outlets {
+			if outlet.ID == "" {
+				return fmt.Errorf("station %q has an outlet without id", station.ID)
+			}
+			if outlets[outlet.ID] {
+				return fmt.Errorf("duplicate outlet id %q", outlet.ID)
+			}
+			outlets[outlet.ID] = true
+		}
+	}
+	for _, id := range c.Outlets {
+		if outlets[id] {
+			return fmt.Errorf("duplicate outlet id %q", id)
+		}
+		outlets[id] = true
+	}
+	return nil
+}
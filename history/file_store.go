@@ -0,0 +1,52 @@
+package history
+
+import (
+	"charge-monitor/journal"
+	"encoding/json"
+	"log/slog"
+	"time"
+)
+
+// FileStore is a MemoryStore whose samples are journaled to a file. Expired
+// samples are dropped from the file when the journal is compacted.
+type FileStore struct {
+	*MemoryStore
+	journal *journal.Mirror
+}
+
+type fileRecord struct {
+	ID     string `json:"id"`
+	Sample Sample `json:"sample"`
+}
+
+func NewFileStore(path string, retention time.Duration) (*FileStore, error) {
+	memory := NewMemoryStore(retention)
+	j, err := journal.OpenMirror(path, func(line []byte) error {
+		var record fileRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return err
+		}
+		memory.Append(record.ID, record.Sample)
+		return nil
+	}, memory.len, memory.snapshot)
+	if err != nil {
+		return nil, err
+	}
+	if err := j.CompactIfNeeded(); err != nil {
+		slog.Error("Failed to compact history journal", "error", err)
+	}
+	return &FileStore{MemoryStore: memory, journal: j}, nil
+}
+
+func (s *FileStore) Append(outletId string, sample Sample) {
+	err := s.journal.Append(fileRecord{ID: outletId, Sample: sample}, func() {
+		s.MemoryStore.Append(outletId, sample)
+	})
+	if err != nil {
+		slog.Error("Failed to write history journal", "outletId", outletId, "error", err)
+	}
+}
+
+func (s *FileStore) Close() error {
+	return s.journal.Close()
+}
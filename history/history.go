@@ -0,0 +1,129 @@
+package history
+
+import (
+	"charge-monitor/cache"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Sample is the status of an outlet at one polling time.
+type Sample struct {
+	// Time is a Unix timestamp in seconds.
+	Time        int64       `json:"time"`
+	Watts       float64     `json:"watts"`
+	UsedMinutes int64       `json:"used_minutes"`
+	State       cache.State `json:"state"`
+}
+
+func SampleOf(info cache.OutletInfo, at time.Time) Sample {
+	return Sample{
+		Time:        at.Unix(),
+		Watts:       info.Watts,
+		UsedMinutes: info.UsedMinutes,
+		State:       info.State,
+	}
+}
+
+type Store interface {
+	// Append records a sample; samples of one outlet are appended in time order.
+	Append(outletId string, sample Sample)
+	// Range returns the samples of an outlet with from <= Time < to.
+	Range(outletId string, from, to int64) []Sample
+	// Close releases the storage behind the store.
+	Close() error
+}
+
+// MemoryStore keeps samples in memory and drops those older than the
+// retention window.
+type MemoryStore struct {
+	retention time.Duration
+	samples   map[string][]Sample
+	// size is the total number of samples held.
+	size int
+	mu   sync.RWMutex
+}
+
+func NewMemoryStore(retention time.Duration) *MemoryStore {
+	return &MemoryStore{
+		retention: retention,
+		samples:   make(map[string][]Sample),
+	}
+}
+
+func (s *MemoryStore) Append(outletId string, sample Sample) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	samples := append(s.samples[outletId], sample)
+	cutoff := sample.Time - int64(s.retention/time.Second)
+	if s.retention > 0 && samples[0].Time < cutoff {
+		expired := sort.Search(len(samples), func(i int) bool { return samples[i].Time >= cutoff })
+		// Copy so the backing array of expired samples can be freed.
+		samples = append([]Sample(nil), samples[expired:]...)
+		s.size -= expired
+	}
+	s.samples[outletId] = samples
+	s.size++
+}
+
+func (s *MemoryStore) Range(outletId string, from, to int64) []Sample {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	samples := s.samples[outletId]
+	start := sort.Search(len(samples), func(i int) bool { return samples[i].Time >= from })
+	end := sort.Search(len(samples), func(i int) bool { return samples[i].Time >= to })
+	if start >= end {
+		return nil
+	}
+	return append([]Sample(nil), samples[start:end]...)
+}
+
+// len returns the number of samples held.
+func (s *MemoryStore) len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.size
+}
+
+// snapshot emits the records of every sample held.
+func (s *MemoryStore) snapshot(emit func(v any) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for id, samples := range s.samples {
+		for _, sample := range samples {
+			if err := emit(fileRecord{ID: id, Sample: sample}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+// Downsample merges samples into buckets of step seconds aligned to the Unix
+// epoch. Each bucket is stamped with its start time and carries the average
+// power plus the used minutes and state of its last sample. Empty buckets are
+// omitted. A step of zero or less returns the samples unchanged.
+func Downsample(samples []Sample, step int64) []Sample {
+	if step <= 0 || len(samples) == 0 {
+		return samples
+	}
+	var buckets []Sample
+	var count int
+	for _, sample := range samples {
+		start := sample.Time - sample.Time%step
+		if len(buckets) == 0 || buckets[len(buckets)-1].Time != start {
+			buckets = append(buckets, Sample{Time: start})
+			count = 0
+		}
+		bucket := &buckets[len(buckets)-1]
+		count++
+		bucket.Watts += (sample.Watts - bucket.Watts) / float64(count)
+		bucket.UsedMinutes = sample.UsedMinutes
+		bucket.State = sample.State
+	}
+	return buckets
+}
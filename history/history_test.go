@@ -0,0 +1,146 @@
+package history
+
+import (
+	"charge-monitor/cache"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemoryStore_Range(t *testing.T) {
+	s := NewMemoryStore(0)
+	for i := range 10 {
+		s.Append("outlet-1", Sample{Time: int64(100 + i*10), Watts: float64(i)})
+	}
+	s.Append("outlet-2", Sample{Time: 120})
+
+	samples := s.Range("outlet-1", 120, 150)
+	if len(samples) != 3 {
+		t.Fatalf("Expected 3 samples in [120, 150), got %d", len(samples))
+	}
+	if samples[0].Time != 120 || samples[2].Time != 140 {
+		t.Errorf("Unexpected samples %+v", samples)
+	}
+
+	if samples := s.Range("outlet-1", 500, 600); len(samples) != 0 {
+		t.Errorf("Expected no samples outside the stored range, got %d", len(samples))
+	}
+	if samples := s.Range("unknown", 0, 1000); len(samples) != 0 {
+		t.Errorf("Expected no samples for unknown outlet, got %d", len(samples))
+	}
+}
+
+func TestMemoryStore_Retention(t *testing.T) {
+	s := NewMemoryStore(time.Minute)
+	s.Append("outlet-1", Sample{Time: 1000})
+	s.Append("outlet-1", Sample{Time: 1030})
+	s.Append("outlet-1", Sample{Time: 1070})
+
+	samples := s.Range("outlet-1", 0, 2000)
+	if len(samples) != 2 || samples[0].Time != 1030 {
+		t.Errorf("Expected samples older than the retention window to be dropped, got %+v", samples)
+	}
+}
+
+func TestDownsample(t *testing.T) {
+	samples := []Sample{
+		{Time: 600, Watts: 80, UsedMinutes: 10, State: cache.StateCharging},
+		{Time: 630, Watts: 100, UsedMinutes: 11, State: cache.StateCharging},
+		{Time: 1250, Watts: 0, UsedMinutes: 20, State: cache.StateFinished},
+	}
+
+	buckets := Downsample(samples, 300)
+	if len(buckets) != 2 {
+		t.Fatalf("Expected 2 non-empty buckets, got %d", len(buckets))
+	}
+	if buckets[0].Time != 600 || buckets[0].Watts != 90 || buckets[0].UsedMinutes != 11 {
+		t.Errorf("Unexpected first bucket %+v", buckets[0])
+	}
+	if buckets[1].Time != 1200 || buckets[1].State != cache.StateFinished {
+		t.Errorf("Unexpected second bucket %+v", buckets[1])
+	}
+
+	if raw := Downsample(samples, 0); len(raw) != 3 {
+		t.Errorf("Expected step 0 to keep raw samples, got %d", len(raw))
+	}
+}
+
+func TestFileStore_SurvivesReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+
+	s, err := NewFileStore(path, time.Hour)
+	if err != nil {
+		t.Fatalf("NewFileStore failed: %v", err)
+	}
+	s.Append("outlet-1", Sample{Time: 1000, Watts: 88, State: cache.StateCharging})
+	s.Append("outlet-1", Sample{Time: 1060, Watts: 90, State: cache.StateCharging})
+	s.Append("outlet-2", Sample{Time: 1060, State: cache.StateIdle})
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	reopened, err := NewFileStore(path, time.Hour)
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	defer reopened.Close()
+	samples := reopened.Range("outlet-1", 0, 2000)
+	if len(samples) != 2 || samples[1].Watts != 90 {
+		t.Errorf("Expected both samples to survive reopening, got %+v", samples)
+	}
+}
+
+func TestFileStore_CompactsExpiredSamples(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	s, err := NewFileStore(path, time.Minute)
+	if err != nil {
+		t.Fatalf("NewFileStore failed: %v", err)
+	}
+	defer s.Close()
+
+	for i := range 5000 {
+		s.Append("outlet-1", Sample{Time: int64(i)})
+	}
+	if records := s.journal.Records(); records > 2*1024 {
+		t.Errorf("Expected expired samples to be compacted away, got %d records", records)
+	}
+	if samples := s.Range("outlet-1", 0, 5000); len(samples) != 61 {
+		t.Errorf("Expected the last minute of samples to be kept, got %d", len(samples))
+	}
+}
+
+func TestFileStore_ConcurrentAppendsJournaledOnce(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	s, err := NewFileStore(path, time.Minute)
+	if err != nil {
+		t.Fatalf("NewFileStore failed: %v", err)
+	}
+	var wg sync.WaitGroup
+	for worker := range 8 {
+		wg.Go(func() {
+			for i := range 5000 {
+				s.Append(fmt.Sprintf("outlet-%d", worker), Sample{Time: int64(i)})
+			}
+		})
+	}
+	wg.Wait()
+	s.Close()
+
+	// Compactions ran while other workers appended, yet no sample may be
+	// journaled twice.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	seen := make(map[string]bool)
+	for line := range strings.Lines(string(data)) {
+		if seen[line] {
+			t.Fatalf("Expected each sample to be journaled once, got %s twice", strings.TrimSpace(line))
+		}
+		seen[line] = true
+	}
+}
@@ -0,0 +1,158 @@
+// Package journal implements an append-only file of JSON records, one per
+// line, that can be replayed on startup and compacted by rewriting it.
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+type Journal struct {
+	path string
+	file *os.File
+	// records counts the lines in the file, for deciding when to compact.
+	records int
+	mu      sync.Mutex
+}
+
+// Open replays every record in the file at path, creating it if needed, and
+// opens it for appending. A truncated last line, as left behind by a crash in
+// the middle of a write, is dropped. replay may be nil for write-only logs.
+func Open(path string, replay func(line []byte) error) (*Journal, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	records, err := replayFile(path, replay)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &Journal{path: path, file: file, records: records}, nil
+}
+
+func replayFile(path string, replay func(line []byte) error) (int, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	records := 0
+	// The offset just past the last complete line, used to cut off a torn write.
+	var valid int64
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			// io.EOF: anything left in line is an unterminated, torn record.
+			break
+		}
+		valid += int64(len(line))
+		if replay != nil {
+			if err := replay(line[:len(line)-1]); err != nil {
+				return 0, err
+			}
+		}
+		records++
+	}
+	if info, err := file.Stat(); err == nil && info.Size() > valid {
+		if err := os.Truncate(path, valid); err != nil {
+			return 0, err
+		}
+	}
+	return records, nil
+}
+
+// Append writes v as one JSON line. Appends are not synced to disk
+// individually: a crashed process loses nothing, a crashed machine may lose
+// the most recent records.
+func (j *Journal) Append(v any) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, err := j.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	j.records++
+	return nil
+}
+
+// Records returns the number of records in the file.
+func (j *Journal) Records() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.records
+}
+
+// Rewrite atomically replaces the journal with the records passed to emit by
+// write. Appends wait until the rewrite is complete.
+func (j *Journal) Rewrite(write func(emit func(v any) error) error) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	tmp, err := os.CreateTemp(filepath.Dir(j.path), filepath.Base(j.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	buffered := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(buffered)
+	records := 0
+	err = write(func(v any) error {
+		records++
+		return encoder.Encode(v)
+	})
+	if err == nil {
+		err = buffered.Flush()
+	}
+	if err == nil {
+		err = tmp.Chmod(0o644)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), j.path); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	j.file.Close()
+	j.file = file
+	j.records = records
+	return nil
+}
+
+// Close syncs and closes the file.
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := j.file.Sync(); err != nil {
+		j.file.Close()
+		return err
+	}
+	return j.file.Close()
+}
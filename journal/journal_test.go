@@ -0,0 +1,136 @@
+package journal
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type record struct {
+	ID    string `json:"id"`
+	Value int    `json:"value"`
+}
+
+func replayInto(records *[]record) func([]byte) error {
+	return func(line []byte) error {
+		var r record
+		if err := json.Unmarshal(line, &r); err != nil {
+			return err
+		}
+		*records = append(*records, r)
+		return nil
+	}
+}
+
+func TestJournal_AppendAndReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", "journal.jsonl")
+
+	j, err := Open(path, replayInto(new([]record)))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	for i := range 3 {
+		if err := j.Append(record{ID: "a", Value: i}); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+	if err := j.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	var replayed []record
+	j, err = Open(path, replayInto(&replayed))
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	defer j.Close()
+	if len(replayed) != 3 || replayed[2].Value != 2 {
+		t.Errorf("Expected 3 replayed records, got %+v", replayed)
+	}
+	if j.Records() != 3 {
+		t.Errorf("Expected 3 records, got %d", j.Records())
+	}
+}
+
+func TestJournal_DropsTornWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	os.WriteFile(path, []byte(`{"id":"a","value":1}`+"\n"+`{"id":"b","val`), 0o644)
+
+	var replayed []record
+	j, err := Open(path, replayInto(&replayed))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if len(replayed) != 1 {
+		t.Errorf("Expected the torn record to be dropped, got %+v", replayed)
+	}
+	j.Append(record{ID: "c", Value: 3})
+	j.Close()
+
+	replayed = nil
+	j, err = Open(path, replayInto(&replayed))
+	if err != nil {
+		t.Fatalf("Reopen after torn write failed: %v", err)
+	}
+	defer j.Close()
+	if len(replayed) != 2 || replayed[1].ID != "c" {
+		t.Errorf("Expected appends after a torn write to be readable, got %+v", replayed)
+	}
+}
+
+func TestJournal_Rewrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	j, _ := Open(path, replayInto(new([]record)))
+	for i := range 10 {
+		j.Append(record{ID: "a", Value: i})
+	}
+
+	err := j.Rewrite(func(emit func(v any) error) error {
+		return emit(record{ID: "a", Value: 9})
+	})
+	if err != nil {
+		t.Fatalf("Rewrite failed: %v", err)
+	}
+	if j.Records() != 1 {
+		t.Errorf("Expected 1 record after rewrite, got %d", j.Records())
+	}
+	j.Append(record{ID: "b", Value: 1})
+	j.Close()
+
+	var replayed []record
+	j, _ = Open(path, replayInto(&replayed))
+	defer j.Close()
+	if len(replayed) != 2 || replayed[0].Value != 9 || replayed[1].ID != "b" {
+		t.Errorf("Unexpected records after rewrite %+v", replayed)
+	}
+}
+
+func TestMirror_CompactsToCollection(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mirror.jsonl")
+	// The collection keeps only the latest record.
+	var latest record
+	m, err := OpenMirror(path, replayInto(new([]record)), func() int { return 1 }, func(emit func(v any) error) error {
+		return emit(latest)
+	})
+	if err != nil {
+		t.Fatalf("OpenMirror failed: %v", err)
+	}
+	for i := range compactRatio*minRecords + 1 {
+		r := record{ID: "a", Value: i}
+		if err := m.Append(r, func() { latest = r }); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+	if m.Records() != 1 {
+		t.Errorf("Expected the journal to be compacted to 1 record, got %d", m.Records())
+	}
+	m.Close()
+
+	var replayed []record
+	j, _ := Open(path, replayInto(&replayed))
+	defer j.Close()
+	if len(replayed) != 1 || replayed[0].Value != compactRatio*minRecords {
+		t.Errorf("Unexpected records after compaction %+v", replayed)
+	}
+}
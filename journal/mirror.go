@@ -0,0 +1,72 @@
+package journal
+
+import (
+	"errors"
+	"sync"
+)
+
+// compactRatio is how many times more records than the mirrored collection
+// holds, and at least minRecords, a Mirror's journal may grow to before it
+// is compacted.
+const (
+	compactRatio = 2
+	minRecords   = 1024
+)
+
+// Mirror journals the writes to an in-memory collection, such as a store
+// that drops expired entries, and compacts the journal down to what the
+// collection still holds once it has grown compactRatio times larger.
+type Mirror struct {
+	journal *Journal
+	// size returns the number of records in the collection, and snapshot
+	// emits each of them.
+	size     func() int
+	snapshot func(emit func(v any) error) error
+	// mu keeps a compaction from snapshotting a record whose line is yet to
+	// be appended, which would then be journaled twice.
+	mu sync.Mutex
+}
+
+// OpenMirror opens the journal at path like Open, replaying it into the
+// collection.
+func OpenMirror(path string, replay func(line []byte) error, size func() int, snapshot func(emit func(v any) error) error) (*Mirror, error) {
+	j, err := Open(path, replay)
+	if err != nil {
+		return nil, err
+	}
+	return &Mirror{journal: j, size: size, snapshot: snapshot}, nil
+}
+
+// Append calls apply to add v to the collection, journals v, and compacts
+// the journal if it has grown too large.
+func (m *Mirror) Append(v any, apply func()) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	apply()
+	return errors.Join(m.journal.Append(v), m.compactIfNeeded())
+}
+
+// CompactIfNeeded compacts the journal if it has grown too large, as Append
+// does.
+func (m *Mirror) CompactIfNeeded() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.compactIfNeeded()
+}
+
+// Records returns the number of records in the journal.
+func (m *Mirror) Records() int {
+	return m.journal.Records()
+}
+
+// compactIfNeeded must be called with mu held.
+func (m *Mirror) compactIfNeeded() error {
+	if m.journal.Records() <= compactRatio*max(m.size(), minRecords) {
+		return nil
+	}
+	return m.journal.Rewrite(m.snapshot)
+}
+
+func (m *Mirror) Close() error {
+	return m.journal.Close()
+}
@@ -0,0 +1,59 @@
+package leader
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// FileLock elects the instance holding an exclusive lock on a file, for
+// replicas sharing one host. The operating system releases the lock when the
+// process exits, so a standby takes over even after a crash.
+type FileLock struct {
+	path string
+	file *os.File
+	mu   sync.Mutex
+}
+
+func NewFileLock(path string) *FileLock {
+	return &FileLock{path: path}
+}
+
+func (l *FileLock) TryAcquire(ctx context.Context) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file != nil {
+		return true, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(l.path), 0o755); err != nil {
+		return false, err
+	}
+	file, err := os.OpenFile(l.path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return false, err
+	}
+	locked, err := tryLock(file)
+	if err != nil || !locked {
+		file.Close()
+		return false, err
+	}
+	l.file = file
+	return true, nil
+}
+
+func (l *FileLock) Release(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	// Closing the file drops the lock.
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+func (l *FileLock) Close() error {
+	return l.Release(context.Background())
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package leader
+
+import (
+	"errors"
+	"os"
+)
+
+func tryLock(file *os.File) (bool, error) {
+	return false, errors.New("file lock leader election is not supported on this platform")
+}
@@ -0,0 +1,77 @@
+// Package leader elects a single instance among replicas to do work that
+// must not run more than once, such as polling the upstream.
+package leader
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Elector campaigns for leadership on behalf of one instance.
+type Elector interface {
+	// TryAcquire takes leadership if it is free, or renews it if this instance
+	// already holds it, and reports whether this instance is the leader.
+	TryAcquire(ctx context.Context) (bool, error)
+	// Release gives up leadership so another instance can take over at once.
+	Release(ctx context.Context) error
+	Close() error
+}
+
+// Run campaigns every interval until ctx is cancelled, running lead while
+// this instance is the leader. Each campaign must finish within interval.
+// lead's context is cancelled as soon as leadership is lost or cannot be
+// confirmed in time, and Run waits for it to return before campaigning again.
+// Leadership is released when Run returns, waiting at most interval.
+func Run(ctx context.Context, e Elector, interval time.Duration, lead func(ctx context.Context)) {
+	// stop cancels lead and waits for it to return; nil while not leading.
+	var stop func()
+	resign := func() {
+		if stop != nil {
+			stop()
+			stop = nil
+		}
+	}
+	defer func() {
+		resign()
+		// Bounded like a campaign, so a dead connection cannot hold up
+		// shutdown.
+		releaseCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), interval)
+		defer cancel()
+		if err := e.Release(releaseCtx); err != nil {
+			slog.Error("Failed to release leadership", "error", err)
+		}
+	}()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return
+		}
+		campaignCtx, cancel := context.WithTimeout(ctx, interval)
+		leader, err := e.TryAcquire(campaignCtx)
+		cancel()
+		if err != nil {
+			slog.Error("Failed to campaign for leadership", "error", err)
+		}
+		switch {
+		case leader && stop == nil:
+			slog.Info("Acquired leadership")
+			leadCtx, cancel := context.WithCancel(ctx)
+			var done sync.WaitGroup
+			done.Go(func() { lead(leadCtx) })
+			stop = func() {
+				cancel()
+				done.Wait()
+			}
+		case !leader && stop != nil:
+			slog.Warn("Lost leadership")
+			resign()
+		}
+		timer.Reset(interval)
+	}
+}
@@ -0,0 +1,233 @@
+package leader
+
+import (
+	"charge-monitor/redis"
+	"charge-monitor/redis/redistest"
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeElector grants leadership while leader is set, and hangs until the
+// campaign or release times out while hang is set.
+type fakeElector struct {
+	leader   atomic.Bool
+	hang     atomic.Bool
+	err      atomic.Pointer[error]
+	released atomic.Bool
+}
+
+func (e *fakeElector) TryAcquire(ctx context.Context) (bool, error) {
+	if e.hang.Load() {
+		<-ctx.Done()
+		return false, ctx.Err()
+	}
+	if err := e.err.Load(); err != nil {
+		return false, *err
+	}
+	return e.leader.Load(), nil
+}
+
+func (e *fakeElector) Release(ctx context.Context) error {
+	e.released.Store(true)
+	if e.hang.Load() {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return nil
+}
+
+func (e *fakeElector) Close() error {
+	return nil
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRun_LeadsOnlyWhileLeader(t *testing.T) {
+	e := &fakeElector{}
+	var leading atomic.Int32
+	var terms atomic.Int32
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		Run(ctx, e, time.Millisecond, func(ctx context.Context) {
+			terms.Add(1)
+			leading.Add(1)
+			<-ctx.Done()
+			leading.Add(-1)
+		})
+	})
+
+	time.Sleep(10 * time.Millisecond)
+	if leading.Load() != 0 {
+		t.Error("Expected not to lead before acquiring leadership")
+	}
+	e.leader.Store(true)
+	waitFor(t, "leadership", func() bool { return leading.Load() == 1 })
+	e.leader.Store(false)
+	waitFor(t, "resignation", func() bool { return leading.Load() == 0 })
+
+	// An election error must stop the leader, since it can no longer be sure.
+	e.leader.Store(true)
+	waitFor(t, "second term", func() bool { return terms.Load() == 2 })
+	err := errors.New("connection refused")
+	e.err.Store(&err)
+	waitFor(t, "resignation on error", func() bool { return leading.Load() == 0 })
+
+	e.err.Store(nil)
+	waitFor(t, "third term", func() bool { return terms.Load() == 3 })
+
+	// So must a campaign that never gets an answer.
+	e.hang.Store(true)
+	waitFor(t, "resignation on timeout", func() bool { return leading.Load() == 0 })
+	e.hang.Store(false)
+	waitFor(t, "fourth term", func() bool { return terms.Load() == 4 })
+	cancel()
+	wg.Wait()
+	if leading.Load() != 0 {
+		t.Error("Expected Run to wait for lead to return")
+	}
+	if !e.released.Load() {
+		t.Error("Expected leadership to be released on return")
+	}
+}
+
+func TestRun_ReleaseIsBounded(t *testing.T) {
+	e := &fakeElector{}
+	e.hang.Store(true)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	done := make(chan struct{})
+	go func() {
+		Run(ctx, e, 10*time.Millisecond, func(ctx context.Context) {})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected Run to return although the release hangs")
+	}
+}
+
+func TestFileLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leader.lock")
+	a, b := NewFileLock(path), NewFileLock(path)
+	ctx := context.Background()
+
+	if ok, err := a.TryAcquire(ctx); !ok || err != nil {
+		t.Fatalf("Expected the first instance to lead, got %v, %v", ok, err)
+	}
+	if ok, _ := a.TryAcquire(ctx); !ok {
+		t.Error("Expected the leader to keep the lock")
+	}
+	if ok, err := b.TryAcquire(ctx); ok || err != nil {
+		t.Errorf("Expected the second instance not to lead, got %v, %v", ok, err)
+	}
+	if err := a.Release(ctx); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	if ok, err := b.TryAcquire(ctx); !ok || err != nil {
+		t.Errorf("Expected the second instance to take over, got %v, %v", ok, err)
+	}
+	b.Release(ctx)
+}
+
+func TestLease(t *testing.T) {
+	server, err := redistest.NewServer("")
+	if err != nil {
+		t.Fatalf("Failed to start redis server: %v", err)
+	}
+	defer server.Close()
+	a := NewLease(redis.NewClient(redis.Options{Address: server.Addr()}), "charge-monitor:leader", 15000)
+	defer a.Close()
+	b := NewLease(redis.NewClient(redis.Options{Address: server.Addr()}), "charge-monitor:leader", 15000)
+	defer b.Close()
+	ctx := context.Background()
+
+	if ok, err := a.TryAcquire(ctx); !ok || err != nil {
+		t.Fatalf("Expected the first instance to lead, got %v, %v", ok, err)
+	}
+	if ok, err := b.TryAcquire(ctx); ok || err != nil {
+		t.Errorf("Expected the second instance not to lead, got %v, %v", ok, err)
+	}
+
+	// Renewals keep the lease alive past its original TTL.
+	server.FastForward(10 * time.Second)
+	if ok, _ := a.TryAcquire(ctx); !ok {
+		t.Fatal("Expected the leader to renew its lease")
+	}
+	server.FastForward(10 * time.Second)
+	if ok, _ := b.TryAcquire(ctx); ok {
+		t.Error("Expected the renewed lease to still be held")
+	}
+
+	// A leader that stops renewing is replaced once the lease expires.
+	server.FastForward(20 * time.Second)
+	if ok, _ := b.TryAcquire(ctx); !ok {
+		t.Fatal("Expected the second instance to take over an expired lease")
+	}
+	if ok, _ := a.TryAcquire(ctx); ok {
+		t.Error("Expected the former leader to notice it lost the lease")
+	}
+
+	// Releasing someone else's lease is a no-op.
+	if err := a.Release(ctx); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	if ok, _ := a.TryAcquire(ctx); ok {
+		t.Error("Expected release by a non-leader to keep the lease")
+	}
+	b.Release(ctx)
+	if ok, _ := a.TryAcquire(ctx); !ok {
+		t.Error("Expected a released lease to be free")
+	}
+}
+
+func TestLease_ChangedOwner(t *testing.T) {
+	server, err := redistest.NewServer("")
+	if err != nil {
+		t.Fatalf("Failed to start redis server: %v", err)
+	}
+	defer server.Close()
+	a := NewLease(redis.NewClient(redis.Options{Address: server.Addr()}), "charge-monitor:leader", 15000)
+	defer a.Close()
+	other := redis.NewClient(redis.Options{Address: server.Addr()})
+	defer other.Close()
+	ctx := context.Background()
+
+	if ok, err := a.TryAcquire(ctx); !ok || err != nil {
+		t.Fatalf("Expected to lead, got %v, %v", ok, err)
+	}
+	// The lease expires and another replica takes it before a renews.
+	server.FastForward(20 * time.Second)
+	if _, err := other.Do(ctx, "SET", "charge-monitor:leader", "other", "NX", "PX", "1000"); err != nil {
+		t.Fatalf("SET failed: %v", err)
+	}
+	if ok, err := a.TryAcquire(ctx); ok || err != nil {
+		t.Errorf("Expected the renewal to fail, got %v, %v", ok, err)
+	}
+	if err := a.Release(ctx); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	if holder, _, _ := other.String(ctx, "GET", "charge-monitor:leader"); holder != "other" {
+		t.Errorf("Expected the other replica to keep its lease, got %q", holder)
+	}
+	// Its TTL must not have been extended by the failed renewal.
+	server.FastForward(2 * time.Second)
+	if server.Keys() != 0 {
+		t.Error("Expected the other replica's lease to expire on its own TTL")
+	}
+}
@@ -0,0 +1,68 @@
+package leader
+
+import (
+	"charge-monitor/redis"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// Lease elects the instance holding a key with a TTL in a Redis-compatible
+// server, for replicas on different hosts. The leader renews the key before it
+// expires; if it stops doing so, another instance takes over once it does.
+type Lease struct {
+	client *redis.Client
+	key    string
+	// id tells this instance's lease apart from the others'.
+	id  string
+	ttl string
+}
+
+// NewLease campaigns for key with a lease of ttlMillis milliseconds, which
+// should be a few times the campaign interval. The lease takes ownership of
+// client.
+func NewLease(client *redis.Client, key string, ttlMillis int64) *Lease {
+	return &Lease{client: client, key: key, id: instanceID(), ttl: strconv.FormatInt(ttlMillis, 10)}
+}
+
+// The lease is renewed and released only if this instance still holds it.
+// Checking the holder and writing in one script keeps a lease that expired
+// and was taken over in between from being renewed or deleted by mistake.
+const (
+	renewScript   = `if redis.call("GET",KEYS[1])==ARGV[1] then return redis.call("PEXPIRE",KEYS[1],ARGV[2]) end return 0`
+	releaseScript = `if redis.call("GET",KEYS[1])==ARGV[1] then return redis.call("DEL",KEYS[1]) end return 0`
+)
+
+func (l *Lease) TryAcquire(ctx context.Context) (bool, error) {
+	reply, err := l.client.Do(ctx, "SET", l.key, l.id, "NX", "PX", l.ttl)
+	if err != nil {
+		return false, err
+	}
+	if reply == "OK" {
+		return true, nil
+	}
+	reply, err = l.client.Do(ctx, "EVAL", renewScript, "1", l.key, l.id, l.ttl)
+	if err != nil {
+		return false, err
+	}
+	return reply == int64(1), nil
+}
+
+func (l *Lease) Release(ctx context.Context) error {
+	_, err := l.client.Do(ctx, "EVAL", releaseScript, "1", l.key, l.id)
+	return err
+}
+
+func (l *Lease) Close() error {
+	return l.client.Close()
+}
+
+func instanceID() string {
+	host, _ := os.Hostname()
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(suffix))
+}
@@ -3,6 +3,10 @@ package main
 import (
 	"charge-monitor/app"
 	"charge-monitor/config"
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
 func main() {
@@ -10,7 +14,15 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
-	a := app.NewApp(config.Outlets, config.PollingInterval, config.HTTPAddress)
-	config.LiveReload(a.UpdateOutlets)
-	a.ServeHTTP()
+	a, err := app.NewApp(config)
+	if err != nil {
+		panic(err)
+	}
+	config.LiveReload(a.UpdateCatalog)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := a.Run(ctx); err != nil {
+		panic(err)
+	}
 }
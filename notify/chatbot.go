@@ -0,0 +1,45 @@
+package notify
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// ChatBot POSTs the message with its target, such as a chat or user ID, to a
+// bot endpoint that relays it to a chat service.
+type ChatBot struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+// NewChatBot sends to url, authenticating with token as a bearer token when
+// it is set. timeout defaults to 10 seconds.
+func NewChatBot(url, token string, timeout time.Duration) *ChatBot {
+	return &ChatBot{url: url, token: token, client: &http.Client{Timeout: cmp.Or(timeout, 10*time.Second)}}
+}
+
+// chatBotRequest is the body sent to the bot endpoint.
+type chatBotRequest struct {
+	Target string `json:"target"`
+	Message
+}
+
+func (b *ChatBot) Validate(target string) error {
+	if target == "" {
+		return errors.New("empty chat target")
+	}
+	return nil
+}
+
+func (b *ChatBot) Notify(ctx context.Context, target string, msg Message) error {
+	body, err := json.Marshal(chatBotRequest{Target: target, Message: msg})
+	if err != nil {
+		return err
+	}
+	return postJSON(ctx, b.client, b.url, b.token, body)
+}
@@ -0,0 +1,144 @@
+// Package notify sends one-off messages to users over pluggable channels.
+package notify
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// Message is a notification, rendered as the channel sees fit.
+type Message struct {
+	// Event names what happened, e.g. "station_free".
+	Event   string `json:"event"`
+	Subject string `json:"subject"`
+	Text    string `json:"text"`
+	// Data is the machine-readable detail of the event.
+	Data any `json:"data,omitempty"`
+}
+
+// Notifier delivers messages to targets whose format depends on the channel:
+// a URL, an e-mail address, a chat ID.
+type Notifier interface {
+	// Validate checks a target when it is registered, long before a message
+	// is sent to it.
+	Validate(target string) error
+	Notify(ctx context.Context, target string, msg Message) error
+}
+
+var ErrUnknownChannel = errors.New("unknown notification channel")
+
+// Channels maps channel names to the notifiers serving them.
+type Channels map[string]Notifier
+
+// Validate checks that channel exists and accepts target.
+func (c Channels) Validate(channel, target string) error {
+	notifier, ok := c[channel]
+	if !ok {
+		return fmt.Errorf("%w %q", ErrUnknownChannel, channel)
+	}
+	return notifier.Validate(target)
+}
+
+func (c Channels) Notify(ctx context.Context, channel, target string, msg Message) error {
+	notifier, ok := c[channel]
+	if !ok {
+		return fmt.Errorf("%w %q", ErrUnknownChannel, channel)
+	}
+	return notifier.Notify(ctx, target, msg)
+}
+
+// ErrPrivateAddress is returned for targets on loopback, private or
+// link-local addresses, which users must not be able to reach through the
+// service.
+var ErrPrivateAddress = errors.New("target address is not public")
+
+// HTTP POSTs the message as JSON to the target URL.
+type HTTP struct {
+	client       *http.Client
+	allowPrivate bool
+}
+
+// NewHTTP returns an HTTP notifier; timeout defaults to 10 seconds. Unless
+// allowPrivate is set, it refuses to connect to non-public addresses, which
+// is checked when dialing so DNS cannot point a target elsewhere later.
+func NewHTTP(timeout time.Duration, allowPrivate bool) *HTTP {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			addr, err := netip.ParseAddrPort(address)
+			if err != nil || !public(addr.Addr()) {
+				return fmt.Errorf("%w: %s", ErrPrivateAddress, address)
+			}
+			return nil
+		}
+	}
+	// No proxy, since the proxy would dial on our behalf.
+	transport := &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: 10 * time.Second}
+	return &HTTP{
+		client:       &http.Client{Timeout: cmp.Or(timeout, 10*time.Second), Transport: transport},
+		allowPrivate: allowPrivate,
+	}
+}
+
+func (h *HTTP) Validate(target string) error {
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid URL %q", target)
+	}
+	if h.allowPrivate {
+		return nil
+	}
+	// Host names are only resolved when dialing.
+	if addr, err := netip.ParseAddr(u.Hostname()); (err == nil && !public(addr)) || u.Hostname() == "localhost" {
+		return fmt.Errorf("%w: %s", ErrPrivateAddress, u.Hostname())
+	}
+	return nil
+}
+
+// public reports whether addr is a unicast address outside loopback, private
+// and link-local ranges.
+func public(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate()
+}
+
+func (h *HTTP) Notify(ctx context.Context, target string, msg Message) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return postJSON(ctx, h.client, target, "", body)
+}
+
+// postJSON sends body to target, with token as a bearer token if set.
+func postJSON(ctx context.Context, client *http.Client, target, token string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
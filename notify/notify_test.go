@@ -0,0 +1,158 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestHTTP_Notify(t *testing.T) {
+	var got Message
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &got)
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+	channels := Channels{"webhook": NewHTTP(0, true)}
+
+	msg := Message{Event: "station_free", Subject: "xzy-4: socket #1 is free"}
+	if err := channels.Notify(context.Background(), "webhook", server.URL, msg); err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+	if got.Event != msg.Event || got.Subject != msg.Subject {
+		t.Errorf("Expected %+v, got %+v", msg, got)
+	}
+	if err := channels.Notify(context.Background(), "webhook", server.URL+"/fail", msg); err == nil {
+		t.Error("Expected an error for a non-2xx response")
+	}
+}
+
+func TestChannels_Validate(t *testing.T) {
+	channels := Channels{"webhook": NewHTTP(0, false)}
+	if err := channels.Validate("webhook", "https://example.com/hook"); err != nil {
+		t.Errorf("Expected a valid target, got %v", err)
+	}
+	if err := channels.Validate("webhook", "file:///etc/passwd"); err == nil {
+		t.Error("Expected a non-HTTP URL to be rejected")
+	}
+	if err := channels.Validate("pigeon", "roof"); !errors.Is(err, ErrUnknownChannel) {
+		t.Errorf("Expected ErrUnknownChannel, got %v", err)
+	}
+}
+
+func TestHTTP_RejectsPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Expected no request to reach a loopback address")
+	}))
+	defer server.Close()
+	h := NewHTTP(0, false)
+
+	for _, target := range []string{"http://127.0.0.1/", "http://10.0.0.8/", "http://169.254.169.254/latest", "http://[::1]/", "http://localhost:8080/"} {
+		if err := h.Validate(target); !errors.Is(err, ErrPrivateAddress) {
+			t.Errorf("Expected %s to be rejected, got %v", target, err)
+		}
+	}
+	// Names resolving to private addresses are caught when dialing.
+	target := strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+	if err := h.Notify(context.Background(), target, Message{Event: "station_free"}); !errors.Is(err, ErrPrivateAddress) {
+		t.Errorf("Expected the connection to be refused, got %v", err)
+	}
+}
+
+func TestChatBot_Notify(t *testing.T) {
+	var got chatBotRequest
+	var auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &got)
+	}))
+	defer server.Close()
+	bot := NewChatBot(server.URL, "bot-token", 0)
+
+	if err := bot.Validate(""); err == nil {
+		t.Error("Expected an empty target to be rejected")
+	}
+	if err := bot.Notify(context.Background(), "chat-42", Message{Event: "outlet_finished", Text: "done"}); err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+	if got.Target != "chat-42" || got.Text != "done" || auth != "Bearer bot-token" {
+		t.Errorf("Unexpected request %+v with authorization %q", got, auth)
+	}
+}
+
+// serveSMTP answers one SMTP session on listener and returns the message data.
+func serveSMTP(t *testing.T, listener net.Listener) <-chan string {
+	data := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		text := textproto.NewConn(conn)
+		text.PrintfLine("220 localhost ESMTP")
+		for {
+			line, err := text.ReadLine()
+			if err != nil {
+				return
+			}
+			switch command := strings.ToUpper(strings.Fields(line)[0]); command {
+			case "EHLO", "HELO":
+				text.PrintfLine("250 localhost")
+			case "DATA":
+				text.PrintfLine("354 go ahead")
+				body, _ := text.ReadDotBytes()
+				data <- string(body)
+				text.PrintfLine("250 queued")
+			case "QUIT":
+				text.PrintfLine("221 bye")
+				return
+			default:
+				text.PrintfLine("250 ok")
+			}
+		}
+	}()
+	return data
+}
+
+func TestSMTP_Notify(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+	data := serveSMTP(t, listener)
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	portNumber, _ := strconv.Atoi(port)
+	mailer := NewSMTP(SMTPOptions{Host: host, Port: portNumber, From: "monitor@example.com"})
+
+	if err := mailer.Validate("Student <student@example.com>"); err == nil {
+		t.Error("Expected a display name to be rejected")
+	}
+	msg := Message{Subject: "学知苑4号: socket #7 finished charging\r\nBcc: evil@example.com", Text: "Socket #7 finished charging after 95 minutes."}
+	if err := mailer.Notify(context.Background(), "student@example.com", msg); err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+	body := <-data
+	if !strings.Contains(body, "To: student@example.com\n") || !strings.Contains(body, "Subject: =?utf-8?q?") {
+		t.Errorf("Unexpected headers in %q", body)
+	}
+	if strings.Contains(body, "\nBcc:") {
+		t.Error("Expected newlines in the subject not to inject headers")
+	}
+	if !strings.Contains(body, "after 95 minutes") {
+		t.Errorf("Expected the text in the body, got %q", body)
+	}
+}
@@ -0,0 +1,115 @@
+package notify
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type SMTPOptions struct {
+	Host string
+	// Port defaults to 587.
+	Port     int
+	Username string
+	Password string
+	// From is the sender address.
+	From string
+	// Timeout bounds each message; defaults to 30 seconds.
+	Timeout time.Duration
+}
+
+// SMTP e-mails the message to the target address, upgrading the connection
+// with STARTTLS when the server offers it.
+type SMTP struct {
+	opts SMTPOptions
+}
+
+func NewSMTP(opts SMTPOptions) *SMTP {
+	opts.Port = cmp.Or(opts.Port, 587)
+	opts.Timeout = cmp.Or(opts.Timeout, 30*time.Second)
+	return &SMTP{opts: opts}
+}
+
+func (s *SMTP) Validate(target string) error {
+	addr, err := mail.ParseAddress(target)
+	if err != nil || addr.Address != target {
+		return fmt.Errorf("invalid e-mail address %q", target)
+	}
+	return nil
+}
+
+func (s *SMTP) Notify(ctx context.Context, target string, msg Message) error {
+	ctx, cancel := context.WithTimeout(ctx, s.opts.Timeout)
+	defer cancel()
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(s.opts.Host, strconv.Itoa(s.opts.Port)))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	client, err := smtp.NewClient(conn, s.opts.Host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: s.opts.Host}); err != nil {
+			return err
+		}
+	}
+	if s.opts.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", s.opts.Username, s.opts.Password, s.opts.Host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(s.opts.From); err != nil {
+		return err
+	}
+	if err := client.Rcpt(target); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(s.compose(target, msg)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// compose renders a plain-text e-mail; the body is quoted-printable so that
+// it needs no 8BITMIME support.
+func (s *SMTP) compose(target string, msg Message) []byte {
+	// Newlines in a header would start a new one.
+	subject := strings.NewReplacer("\r", " ", "\n", " ").Replace(msg.Subject)
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", s.opts.From)
+	fmt.Fprintf(&buf, "To: %s\r\n", target)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	body := quotedprintable.NewWriter(&buf)
+	body.Write([]byte(msg.Text))
+	body.Close()
+	buf.WriteString("\r\n")
+	return buf.Bytes()
+}
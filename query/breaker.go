@@ -0,0 +1,129 @@
+package query
+
+import (
+	"charge-monitor/cache"
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without contacting the upstream while the
+// circuit breaker is open.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+type BreakerState string
+
+const (
+	BreakerClosed   BreakerState = "closed"
+	BreakerOpen     BreakerState = "open"
+	BreakerHalfOpen BreakerState = "half-open"
+)
+
+type BreakerStatus struct {
+	State               BreakerState `json:"state"`
+	ConsecutiveFailures int          `json:"consecutive_failures"`
+	// OpenedAt is when the breaker last opened, zero if it never did.
+	OpenedAt int64 `json:"opened_at,omitempty"`
+}
+
+// Breaker is a Provider that stops calling the wrapped provider after
+// threshold consecutive failures across all outlets. Once cooldown has passed
+// a single probe request is let through: success closes the breaker again,
+// failure reopens it for another cooldown.
+//
+// Only failures of the upstream itself count; a ResponseCodeError is a valid
+// answer about one outlet and counts as success.
+type Breaker struct {
+	Provider
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func NewBreaker(provider Provider, threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{
+		Provider:  provider,
+		threshold: threshold,
+		cooldown:  cooldown,
+		state:     BreakerClosed,
+	}
+}
+
+func (b *Breaker) QueryChargeStatus(ctx context.Context, outletId string) (cache.OutletInfo, error) {
+	if err := b.allow(); err != nil {
+		return cache.OutletInfo{}, err
+	}
+	info, err := b.Provider.QueryChargeStatus(ctx, outletId)
+	b.record(ctx, err)
+	return info, err
+}
+
+func (b *Breaker) Status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	status := BreakerStatus{State: b.state, ConsecutiveFailures: b.failures}
+	if !b.openedAt.IsZero() {
+		status.OpenedAt = b.openedAt.Unix()
+	}
+	return status
+}
+
+func (b *Breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return ErrCircuitOpen
+		}
+		b.setState(BreakerHalfOpen)
+		b.probing = true
+		return nil
+	case BreakerHalfOpen:
+		if b.probing {
+			return ErrCircuitOpen
+		}
+		b.probing = true
+		return nil
+	default:
+		return nil
+	}
+}
+
+func (b *Breaker) record(ctx context.Context, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	var codeErr *ResponseCodeError
+	if err == nil || errors.As(err, &codeErr) {
+		b.failures = 0
+		if b.state != BreakerClosed {
+			b.setState(BreakerClosed)
+		}
+		return
+	}
+	if ctx.Err() != nil {
+		// We gave up on the request ourselves, which says nothing about the upstream.
+		return
+	}
+	b.failures++
+	if b.state == BreakerHalfOpen || b.failures >= b.threshold {
+		b.openedAt = time.Now()
+		if b.state != BreakerOpen {
+			b.setState(BreakerOpen)
+		}
+	}
+}
+
+// setState must be called with b.mu held.
+func (b *Breaker) setState(state BreakerState) {
+	slog.Warn("Circuit breaker state changed", "provider", b.Name(), "from", b.state, "to", state, "failures", b.failures)
+	b.state = state
+}
@@ -0,0 +1,86 @@
+package query
+
+import (
+	"charge-monitor/cache"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type stubProvider struct {
+	err   error
+	calls int
+}
+
+func (p *stubProvider) Name() string { return "stub" }
+
+func (p *stubProvider) Type() string { return "stub" }
+
+func (p *stubProvider) Capabilities() Capabilities { return Capabilities{} }
+
+func (p *stubProvider) QueryChargeStatus(ctx context.Context, outletId string) (cache.OutletInfo, error) {
+	p.calls++
+	return cache.OutletInfo{}, p.err
+}
+
+func (p *stubProvider) Close() error { return nil }
+
+func TestBreaker_OpensAfterThreshold(t *testing.T) {
+	stub := &stubProvider{err: errors.New("connection refused")}
+	breaker := NewBreaker(stub, 3, time.Hour)
+
+	for i := range 3 {
+		if _, err := breaker.QueryChargeStatus(context.Background(), "outlet"); errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("Expected request %d to reach the upstream", i+1)
+		}
+	}
+	if state := breaker.Status().State; state != BreakerOpen {
+		t.Fatalf("Expected breaker to be open after 3 failures, got %s", state)
+	}
+
+	if _, err := breaker.QueryChargeStatus(context.Background(), "outlet"); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Expected ErrCircuitOpen, got %v", err)
+	}
+	if stub.calls != 3 {
+		t.Errorf("Expected the open breaker not to call the upstream, got %d calls", stub.calls)
+	}
+}
+
+func TestBreaker_HalfOpenProbe(t *testing.T) {
+	stub := &stubProvider{err: errors.New("connection refused")}
+	breaker := NewBreaker(stub, 1, 10*time.Millisecond)
+
+	breaker.QueryChargeStatus(context.Background(), "outlet")
+	time.Sleep(20 * time.Millisecond)
+
+	// A failed probe reopens the breaker.
+	breaker.QueryChargeStatus(context.Background(), "outlet")
+	if state := breaker.Status().State; state != BreakerOpen {
+		t.Fatalf("Expected breaker to reopen after a failed probe, got %s", state)
+	}
+	if stub.calls != 2 {
+		t.Errorf("Expected the probe to reach the upstream, got %d calls", stub.calls)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	stub.err = nil
+	if _, err := breaker.QueryChargeStatus(context.Background(), "outlet"); err != nil {
+		t.Fatalf("Expected the probe to succeed, got %v", err)
+	}
+	if state := breaker.Status().State; state != BreakerClosed {
+		t.Errorf("Expected breaker to close after a successful probe, got %s", state)
+	}
+}
+
+func TestBreaker_IgnoresResponseCodeErrors(t *testing.T) {
+	stub := &stubProvider{err: &ResponseCodeError{Code: "0"}}
+	breaker := NewBreaker(stub, 2, time.Hour)
+
+	for range 5 {
+		breaker.QueryChargeStatus(context.Background(), "outlet")
+	}
+	if status := breaker.Status(); status.State != BreakerClosed || status.ConsecutiveFailures != 0 {
+		t.Errorf("Expected outlet-level errors not to trip the breaker, got %+v", status)
+	}
+}
@@ -0,0 +1,29 @@
+//go:build integration
+
+package query
+
+import (
+	"context"
+	"testing"
+)
+
+// Integration test (will make real HTTP request)
+// Run with: go test -tags=integration
+func TestQueryChargeStatus_Integration(t *testing.T) {
+	outletId := "O201222013860646"
+	info, err := NewQuerier(Options{}).QueryChargeStatus(context.Background(), outletId)
+
+	if err != nil {
+		t.Fatalf("Integration test failed: %v", err)
+	}
+
+	t.Logf("Retrieved power value: %s, state: %s", info.Power, info.State)
+
+	// Basic validation that we got some response
+	if info.Power == "" {
+		t.Error("Expected non-empty power value")
+	}
+	if info.UsedMinutes == 0 {
+		t.Error("Expected non-empty used minutes value")
+	}
+}
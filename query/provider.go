@@ -0,0 +1,38 @@
+package query
+
+import (
+	"charge-monitor/cache"
+	"context"
+	"fmt"
+)
+
+// ProviderWemp is the provider type of the wemp.issks.com API.
+const ProviderWemp = "wemp"
+
+// Capabilities describes which OutletInfo fields a provider fills in.
+type Capabilities struct {
+	Power       bool `json:"power"`
+	UsedMinutes bool `json:"used_minutes"`
+	State       bool `json:"state"`
+}
+
+// Provider fetches outlet status from one charging vendor.
+type Provider interface {
+	Name() string
+	Type() string
+	Capabilities() Capabilities
+	QueryChargeStatus(ctx context.Context, outletId string) (cache.OutletInfo, error)
+	Close() error
+}
+
+// NewProvider creates a provider of the given type. An empty type selects
+// ProviderWemp.
+func NewProvider(name, providerType string, opts Options) (Provider, error) {
+	switch providerType {
+	case "", ProviderWemp:
+		opts.Name = name
+		return NewQuerier(opts), nil
+	default:
+		return nil, fmt.Errorf("provider %q has unknown type %q", name, providerType)
+	}
+}
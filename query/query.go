@@ -1,33 +1,166 @@
 package query
 
 import (
+	"charge-monitor/cache"
+	"context"
 	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/tidwall/gjson"
 	"resty.dev/v3"
 )
 
-// create client once
-var client = resty.New()
+// DefaultBaseURL is the wemp.issks.com API used when no base URL is configured.
+const DefaultBaseURL = "https://wemp.issks.com"
 
-func QueryChargeStatus(outletId string) (string, int64, error) {
-	resp, err := client.R().Get("https://wemp.issks.com/charge/v1/charging/outlet/" + outletId)
+// ResponseCodeError is returned when the upstream answers with a non-success
+// code, which it does for outlets that are faulty or offline.
+type ResponseCodeError struct {
+	Code string
+}
+
+func (e *ResponseCodeError) Error() string {
+	return "unexpected response code: " + e.Code
+}
+
+type Options struct {
+	// Name identifies the provider in logs and the API; defaults to ProviderWemp.
+	Name string
+	// BaseURL defaults to DefaultBaseURL.
+	BaseURL string
+	// Timeout bounds a single request; zero means no timeout.
+	Timeout   time.Duration
+	UserAgent string
+	// Transport overrides the HTTP transport, e.g. to go through a proxy.
+	Transport http.RoundTripper
+}
+
+// Querier queries outlet status from the wemp.issks.com API. It implements
+// Provider.
+type Querier struct {
+	name   string
+	client *resty.Client
+}
+
+func NewQuerier(opts Options) *Querier {
+	if opts.Name == "" {
+		opts.Name = ProviderWemp
+	}
+	if opts.BaseURL == "" {
+		opts.BaseURL = DefaultBaseURL
+	}
+	client := resty.New().SetBaseURL(strings.TrimSuffix(opts.BaseURL, "/"))
+	if opts.Timeout > 0 {
+		client.SetTimeout(opts.Timeout)
+	}
+	if opts.UserAgent != "" {
+		client.SetHeader("User-Agent", opts.UserAgent)
+	}
+	if opts.Transport != nil {
+		client.SetTransport(opts.Transport)
+	}
+	return &Querier{name: opts.Name, client: client}
+}
+
+func (q *Querier) Name() string {
+	return q.name
+}
+
+func (q *Querier) Type() string {
+	return ProviderWemp
+}
+
+func (q *Querier) Capabilities() Capabilities {
+	return Capabilities{Power: true, UsedMinutes: true, State: true}
+}
+
+func (q *Querier) QueryChargeStatus(ctx context.Context, outletId string) (cache.OutletInfo, error) {
+	resp, err := q.client.R().
+		SetContext(ctx).
+		SetPathParam("outletId", outletId).
+		Get("/charge/v1/charging/outlet/{outletId}")
 
 	if err != nil {
-		return "", 0, err
+		return cache.OutletInfo{}, err
 	}
 
 	if resp.StatusCode() != 200 {
-		return "", 0, errors.New("request failed with status code: " + resp.Status())
+		return cache.OutletInfo{}, errors.New("request failed with status code: " + resp.Status())
 	}
 	body := resp.Bytes()
 
-	if gjson.GetBytes(body, "code").String() != "1" {
-		return "", 0, errors.New("unexpected response code: " + gjson.GetBytes(body, "code").String())
+	if code := gjson.GetBytes(body, "code").String(); code != "1" {
+		return cache.OutletInfo{}, &ResponseCodeError{Code: code}
+	}
+
+	return parseOutletInfo(body), nil
+}
+
+func (q *Querier) Close() error {
+	return q.client.Close()
+}
+
+func parseOutletInfo(body []byte) cache.OutletInfo {
+	data := gjson.GetBytes(body, "data")
+	if !data.IsObject() {
+		return cache.OutletInfo{State: cache.StateUnknown}
+	}
+
+	power := data.Get("powerFee.billingPower").String()
+	usedMinutes := data.Get("usedmin").Int()
+
+	// Values that are not a power, such as "N/A", mean nothing is drawn.
+	watts, _ := ParseWatts(power)
+
+	return cache.OutletInfo{
+		Power:       power,
+		Watts:       watts,
+		UsedMinutes: usedMinutes,
+		State:       deriveState(watts, usedMinutes),
 	}
+}
 
-	power := gjson.GetBytes(body, "data.powerFee.billingPower").String()
-	usedMinutes := gjson.GetBytes(body, "data.usedmin").Int()
+// deriveState infers the occupancy state from the billing power and the
+// minutes used by the current session. A socket draws power while charging;
+// a session that has used minutes but no longer draws power is finished but
+// still plugged in; anything else is idle.
+func deriveState(watts float64, usedMinutes int64) cache.State {
+	if watts > 0 {
+		return cache.StateCharging
+	}
+	if usedMinutes > 0 {
+		return cache.StateFinished
+	}
+	return cache.StateIdle
+}
 
-	return power, usedMinutes, nil
+// ParseWatts converts a power string such as "88W", "1.2kW" or "88" to watts.
+// An empty value is 0; unparsable, negative and non-finite values are errors.
+func ParseWatts(power string) (float64, error) {
+	value := strings.ToLower(strings.TrimSpace(power))
+	multiplier := 1.0
+	switch {
+	case strings.HasSuffix(value, "kw"):
+		value = strings.TrimSuffix(value, "kw")
+		multiplier = 1000
+	case strings.HasSuffix(value, "w"):
+		value = strings.TrimSuffix(value, "w")
+	}
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+	watts, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid power %q", power)
+	}
+	if watts < 0 || math.IsNaN(watts) || math.IsInf(watts, 0) {
+		return 0, fmt.Errorf("invalid power %q", power)
+	}
+	return watts * multiplier, nil
 }
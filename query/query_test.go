@@ -1,13 +1,12 @@
 package query
 
 import (
-	"errors"
+	"charge-monitor/cache"
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"testing"
-
-	"github.com/tidwall/gjson"
-	"resty.dev/v3"
+	"time"
 )
 
 func TestQueryChargeStatus_Success(t *testing.T) {
@@ -35,23 +34,19 @@ func TestQueryChargeStatus_Success(t *testing.T) {
 	}))
 	defer mockServer.Close()
 
-	// Test the function with a mock outlet ID
-	// Note: This test will still call the real API. To properly mock it,
-	// we would need to modify the QueryChargeStatus function to accept
-	// a configurable base URL or HTTP client.
 	outletId := "test-outlet-123"
-
-	// Since we can't easily mock the real function without refactoring,
-	// let's create a separate testable version for demonstration
-	power, err := queryChargeStatusWithBaseURL(outletId, mockServer.URL)
+	info, err := NewQuerier(Options{BaseURL: mockServer.URL}).QueryChargeStatus(context.Background(), outletId)
 
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
 
 	expectedPower := "88W"
-	if power != expectedPower {
-		t.Errorf("Expected power %s, got %s", expectedPower, power)
+	if info.Power != expectedPower {
+		t.Errorf("Expected power %s, got %s", expectedPower, info.Power)
+	}
+	if info.Watts != 88 {
+		t.Errorf("Expected 88 watts, got %v", info.Watts)
 	}
 }
 
@@ -64,7 +59,7 @@ func TestQueryChargeStatus_HTTPError(t *testing.T) {
 	defer mockServer.Close()
 
 	outletId := "test-outlet-123"
-	_, err := queryChargeStatusWithBaseURL(outletId, mockServer.URL)
+	_, err := NewQuerier(Options{BaseURL: mockServer.URL}).QueryChargeStatus(context.Background(), outletId)
 
 	if err == nil {
 		t.Fatal("Expected an error for HTTP 500, got nil")
@@ -91,7 +86,7 @@ func TestQueryChargeStatus_InvalidResponseCode(t *testing.T) {
 	defer mockServer.Close()
 
 	outletId := "test-outlet-123"
-	_, err := queryChargeStatusWithBaseURL(outletId, mockServer.URL)
+	_, err := NewQuerier(Options{BaseURL: mockServer.URL}).QueryChargeStatus(context.Background(), outletId)
 
 	if err == nil {
 		t.Fatal("Expected an error for invalid response code, got nil")
@@ -120,63 +115,144 @@ func TestQueryChargeStatus_MissingPowerData(t *testing.T) {
 	defer mockServer.Close()
 
 	outletId := "test-outlet-123"
-	power, err := queryChargeStatusWithBaseURL(outletId, mockServer.URL)
+	info, err := NewQuerier(Options{BaseURL: mockServer.URL}).QueryChargeStatus(context.Background(), outletId)
 
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
 
 	// gjson returns empty string when field doesn't exist
-	if power != "" {
-		t.Errorf("Expected empty power value, got %s", power)
+	if info.Power != "" {
+		t.Errorf("Expected empty power value, got %s", info.Power)
 	}
 }
 
-// Helper function for testing with configurable base URL
-// This demonstrates how the original function could be refactored for better testability
-func queryChargeStatusWithBaseURL(outletId string, baseURL string) (string, error) {
-	// This is a copy of the original function but with configurable base URL
-	// In a real refactor, you might inject the HTTP client or base URL
-
-	client := resty.New()
-	url := baseURL + "/charge/v1/charging/outlet/" + outletId
-	resp, err := client.R().Get(url)
+func TestParseOutletInfo_State(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected cache.State
+	}{
+		{"charging", `{"code":"1","data":{"powerFee":{"billingPower":"88W"},"usedmin":35}}`, cache.StateCharging},
+		{"just plugged in", `{"code":"1","data":{"powerFee":{"billingPower":"120W"},"usedmin":0}}`, cache.StateCharging},
+		{"finished but plugged", `{"code":"1","data":{"powerFee":{"billingPower":"0W"},"usedmin":240}}`, cache.StateFinished},
+		{"finished without power", `{"code":"1","data":{"powerFee":{},"usedmin":240}}`, cache.StateFinished},
+		{"idle", `{"code":"1","data":{"powerFee":{},"usedmin":0}}`, cache.StateIdle},
+		{"no data", `{"code":"1","data":null}`, cache.StateUnknown},
+	}
 
-	if err != nil {
-		return "", err
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := parseOutletInfo([]byte(tt.body))
+			if info.State != tt.expected {
+				t.Errorf("Expected state %s, got %s", tt.expected, info.State)
+			}
+		})
 	}
+}
 
-	if resp.StatusCode() != 200 {
-		return "", errors.New("request failed with status code: " + resp.Status())
+func TestParseWatts(t *testing.T) {
+	tests := []struct {
+		power    string
+		expected float64
+	}{
+		{"88W", 88},
+		{"88w", 88},
+		{" 88 W ", 88},
+		{"1.2kW", 1200},
+		{"1.5KW", 1500},
+		{"120", 120},
+		{"0W", 0},
+		{"", 0},
 	}
-	body := resp.Bytes()
 
-	if gjson.GetBytes(body, "code").String() != "1" {
-		return "", errors.New("unexpected response code: " + gjson.GetBytes(body, "code").String())
+	for _, tt := range tests {
+		if watts, err := ParseWatts(tt.power); watts != tt.expected || err != nil {
+			t.Errorf("ParseWatts(%q): expected %v, got %v, %v", tt.power, tt.expected, watts, err)
+		}
 	}
+	for _, power := range []string{"N/A", "-5W", "NaN", "NaNW", "Inf", "+InfkW", "-Inf"} {
+		if watts, err := ParseWatts(power); err == nil {
+			t.Errorf("ParseWatts(%q): expected an error, got %v", power, watts)
+		}
+	}
+}
 
-	power := gjson.GetBytes(body, "data.powerFee.billingPower").String()
+func TestQuerier_Options(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ua := r.Header.Get("User-Agent"); ua != "charge-monitor-test" {
+			t.Errorf("Expected User-Agent charge-monitor-test, got %s", ua)
+		}
+		if r.URL.Path != "/prefix/charge/v1/charging/outlet/test-outlet-123" {
+			t.Errorf("Unexpected path %s", r.URL.Path)
+		}
+		w.Write([]byte(`{"code":"1","data":{"powerFee":{"billingPower":"88W"}}}`))
+	}))
+	defer mockServer.Close()
 
-	return power, nil
+	q := NewQuerier(Options{
+		BaseURL:   mockServer.URL + "/prefix/",
+		UserAgent: "charge-monitor-test",
+		Timeout:   time.Second,
+	})
+	defer q.Close()
+	if _, err := q.QueryChargeStatus(context.Background(), "test-outlet-123"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// The transport sees every request, which is how a proxy gets plugged in.
+	var requests int
+	q = NewQuerier(Options{
+		BaseURL:   mockServer.URL + "/prefix",
+		UserAgent: "charge-monitor-test",
+		Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			requests++
+			return http.DefaultTransport.RoundTrip(r)
+		}),
+	})
+	defer q.Close()
+	if _, err := q.QueryChargeStatus(context.Background(), "test-outlet-123"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if requests != 1 {
+		t.Errorf("Expected the custom transport to see 1 request, got %d", requests)
+	}
 }
 
-// Integration test (will make real HTTP request)
-// Run with: go test -tags=integration
-func TestQueryChargeStatus_Integration(t *testing.T) {
-	outletId := "O201222013860646"
-	power, usedMinutes, err := QueryChargeStatus(outletId)
+func TestQuerier_Timeout(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte(`{"code":"1","data":{}}`))
+	}))
+	defer mockServer.Close()
 
-	if err != nil {
-		t.Fatalf("Integration test failed: %v", err)
+	q := NewQuerier(Options{BaseURL: mockServer.URL, Timeout: 50 * time.Millisecond})
+	defer q.Close()
+	if _, err := q.QueryChargeStatus(context.Background(), "test-outlet-123"); err == nil {
+		t.Fatal("Expected a timeout error, got nil")
 	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
 
-	t.Logf("Retrieved power value: %s", power)
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
 
-	// Basic validation that we got some response
-	if power == "" {
-		t.Error("Expected non-empty power value")
+func TestNewProvider(t *testing.T) {
+	provider, err := NewProvider("campus", "", Options{})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer provider.Close()
+	if provider.Name() != "campus" || provider.Type() != ProviderWemp {
+		t.Errorf("Expected wemp provider named campus, got %s provider named %s", provider.Type(), provider.Name())
 	}
-	if usedMinutes == 0 {
-		t.Error("Expected non-empty used minutes value")
+	if !provider.Capabilities().State {
+		t.Error("Expected wemp provider to report state")
+	}
+
+	if _, err := NewProvider("other", "unknown-vendor", Options{}); err == nil {
+		t.Error("Expected an error for an unknown provider type")
 	}
 }
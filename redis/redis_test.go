@@ -0,0 +1,122 @@
+package redis_test
+
+import (
+	"charge-monitor/redis"
+	"charge-monitor/redis/redistest"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newServer(t *testing.T, password string) *redistest.Server {
+	server, err := redistest.NewServer(password)
+	if err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestClient_Commands(t *testing.T) {
+	server := newServer(t, "secret")
+	client := redis.NewClient(redis.Options{Address: server.Addr(), Password: "secret", DB: 1})
+	defer client.Close()
+	ctx := context.Background()
+
+	if _, ok, err := client.String(ctx, "GET", "missing"); err != nil || ok {
+		t.Errorf("Expected a null reply for a missing key, got ok=%v err=%v", ok, err)
+	}
+	if _, err := client.Do(ctx, "SET", "key", "value with\r\nnewlines", "PX", "1000"); err != nil {
+		t.Fatalf("SET failed: %v", err)
+	}
+	if value, ok, err := client.String(ctx, "GET", "key"); err != nil || !ok || value != "value with\r\nnewlines" {
+		t.Errorf("Expected the stored value, got %q ok=%v err=%v", value, ok, err)
+	}
+	if reply, _ := client.Do(ctx, "SET", "key", "other", "NX"); reply != nil {
+		t.Errorf("Expected SET NX on an existing key to be refused, got %v", reply)
+	}
+	if reply, err := client.Do(ctx, "MGET", "key", "missing"); err != nil || len(reply.([]any)) != 2 || reply.([]any)[1] != nil {
+		t.Errorf("Unexpected MGET reply %v, err %v", reply, err)
+	}
+
+	server.FastForward(2 * time.Second)
+	if _, ok, _ := client.String(ctx, "GET", "key"); ok {
+		t.Error("Expected the key to expire")
+	}
+
+	var replyErr redis.Error
+	if _, err := client.Do(ctx, "NOPE"); !errors.As(err, &replyErr) {
+		t.Errorf("Expected an error reply, got %v", err)
+	}
+	// The connection is still usable after an error reply.
+	if reply, err := client.Do(ctx, "PING"); err != nil || reply != "PONG" {
+		t.Errorf("Expected PONG, got %v, err %v", reply, err)
+	}
+}
+
+func TestClient_WrongPassword(t *testing.T) {
+	server := newServer(t, "secret")
+	client := redis.NewClient(redis.Options{Address: server.Addr(), Password: "wrong"})
+	defer client.Close()
+
+	if _, err := client.Do(context.Background(), "PING"); err == nil {
+		t.Error("Expected authentication to fail")
+	}
+}
+
+func TestClient_Concurrent(t *testing.T) {
+	server := newServer(t, "")
+	client := redis.NewClient(redis.Options{Address: server.Addr(), PoolSize: 2})
+	defer client.Close()
+
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Go(func() {
+			if _, err := client.Do(context.Background(), "SET", "key", string(rune('a'+i))); err != nil {
+				t.Errorf("SET failed: %v", err)
+			}
+		})
+	}
+	wg.Wait()
+	if server.Keys() != 1 {
+		t.Errorf("Expected 1 key, got %d", server.Keys())
+	}
+}
+
+func TestClient_Subscribe(t *testing.T) {
+	server := newServer(t, "")
+	client := redis.NewClient(redis.Options{Address: server.Addr()})
+	defer client.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	messages := make(chan string, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- client.Subscribe(ctx, "changes", func(message string) { messages <- message })
+	}()
+	// Publish until the subscription is active.
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		reply, err := client.Do(context.Background(), "PUBLISH", "changes", "hello")
+		if err != nil {
+			t.Fatalf("PUBLISH failed: %v", err)
+		}
+		if reply == int64(1) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the subscription")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if message := <-messages; message != "hello" {
+		t.Errorf("Expected hello, got %q", message)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Expected nil after cancellation, got %v", err)
+	}
+}
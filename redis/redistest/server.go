@@ -0,0 +1,378 @@
+// Package redistest provides an in-process server speaking the Redis
+// protocol, for tests. It supports the commands used by this module: PING,
+// AUTH, SELECT, GET, SET (with EX, PX, NX and XX), DEL, MGET, SCAN and
+// PEXPIRE, SUBSCRIBE and PUBLISH, and EVAL of scripts that run a command on a
+// key only if it holds a given value.
+package redistest
+
+import (
+	"bufio"
+	"charge-monitor/redis"
+	"fmt"
+	"net"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Server struct {
+	listener net.Listener
+	password string
+
+	mu     sync.Mutex
+	data   map[string]entry
+	offset time.Duration
+	conns  map[net.Conn]bool
+	// subscribers holds the connections subscribed to each channel. Messages
+	// are written with mu held, so they do not interleave.
+	subscribers map[string]map[net.Conn]bool
+	wg          sync.WaitGroup
+}
+
+type entry struct {
+	value   string
+	expires time.Time
+}
+
+// NewServer starts a server on a random local port. A non-empty password
+// makes AUTH mandatory.
+func NewServer(password string) (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		listener:    listener,
+		password:    password,
+		data:        make(map[string]entry),
+		conns:       make(map[net.Conn]bool),
+		subscribers: make(map[string]map[net.Conn]bool),
+	}
+	s.wg.Go(s.serve)
+	return s, nil
+}
+
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// FastForward advances the server clock, expiring keys as if d had passed.
+func (s *Server) FastForward(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.offset += d
+}
+
+// Keys returns the number of live keys.
+func (s *Server) Keys() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	count := 0
+	for key := range s.data {
+		if _, ok := s.lookup(key); ok {
+			count++
+		}
+	}
+	return count
+}
+
+func (s *Server) Close() {
+	s.listener.Close()
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+func (s *Server) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = true
+		s.mu.Unlock()
+		s.wg.Go(func() { s.handle(conn) })
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		for _, conns := range s.subscribers {
+			delete(conns, conn)
+		}
+		s.mu.Unlock()
+		conn.Close()
+	}()
+	reader := bufio.NewReader(conn)
+	authenticated := s.password == ""
+	for {
+		request, err := redis.ReadReply(reader)
+		if err != nil {
+			return
+		}
+		items, ok := request.([]any)
+		if !ok || len(items) == 0 {
+			conn.Write([]byte("-ERR expected a command array\r\n"))
+			continue
+		}
+		args := make([]string, len(items))
+		for i, item := range items {
+			args[i], _ = item.(string)
+		}
+		name := strings.ToUpper(args[0])
+		if name == "AUTH" {
+			if len(args) == 2 && args[1] == s.password {
+				authenticated = true
+				conn.Write([]byte("+OK\r\n"))
+			} else {
+				conn.Write([]byte("-WRONGPASS invalid password\r\n"))
+			}
+			continue
+		}
+		if !authenticated {
+			conn.Write([]byte("-NOAUTH Authentication required.\r\n"))
+			continue
+		}
+		if name == "SUBSCRIBE" {
+			s.subscribe(conn, args[1:])
+			continue
+		}
+		conn.Write(s.execute(name, args[1:]))
+	}
+}
+
+func (s *Server) subscribe(conn net.Conn, channels []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, channel := range channels {
+		if s.subscribers[channel] == nil {
+			s.subscribers[channel] = make(map[net.Conn]bool)
+		}
+		s.subscribers[channel][conn] = true
+		reply := array(3)
+		reply = append(reply, bulk("subscribe", true)...)
+		reply = append(reply, bulk(channel, true)...)
+		reply = append(reply, integer(len(channels))...)
+		conn.Write(reply)
+	}
+}
+
+func (s *Server) execute(name string, args []string) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.run(name, args)
+}
+
+// run must be called with s.mu held.
+func (s *Server) run(name string, args []string) []byte {
+	switch name {
+	case "PING":
+		return []byte("+PONG\r\n")
+	case "SELECT":
+		return []byte("+OK\r\n")
+	case "GET":
+		if len(args) != 1 {
+			return wrongArgs(name)
+		}
+		value, ok := s.lookup(args[0])
+		return bulk(value, ok)
+	case "SET":
+		return s.set(args)
+	case "DEL":
+		deleted := 0
+		for _, key := range args {
+			if _, ok := s.lookup(key); ok {
+				deleted++
+			}
+			delete(s.data, key)
+		}
+		return integer(deleted)
+	case "MGET":
+		reply := array(len(args))
+		for _, key := range args {
+			value, ok := s.lookup(key)
+			reply = append(reply, bulk(value, ok)...)
+		}
+		return reply
+	case "SCAN":
+		return s.scan(args)
+	case "PEXPIRE":
+		if len(args) != 2 {
+			return wrongArgs(name)
+		}
+		ms, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return []byte("-ERR value is not an integer or out of range\r\n")
+		}
+		e, ok := s.data[args[0]]
+		if _, live := s.lookup(args[0]); !ok || !live {
+			return integer(0)
+		}
+		e.expires = s.now().Add(time.Duration(ms) * time.Millisecond)
+		s.data[args[0]] = e
+		return integer(1)
+	case "EVAL":
+		return s.eval(args)
+	case "PUBLISH":
+		if len(args) != 2 {
+			return wrongArgs(name)
+		}
+		message := array(3)
+		message = append(message, bulk("message", true)...)
+		message = append(message, bulk(args[0], true)...)
+		message = append(message, bulk(args[1], true)...)
+		for conn := range s.subscribers[args[0]] {
+			conn.Write(message)
+		}
+		return integer(len(s.subscribers[args[0]]))
+	default:
+		return []byte(fmt.Sprintf("-ERR unknown command '%s'\r\n", name))
+	}
+}
+
+func (s *Server) set(args []string) []byte {
+	if len(args) < 2 {
+		return wrongArgs("SET")
+	}
+	key, value := args[0], args[1]
+	var expires time.Time
+	var nx, xx bool
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "EX", "PX":
+			if i+1 >= len(args) {
+				return []byte("-ERR syntax error\r\n")
+			}
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil || n <= 0 {
+				return []byte("-ERR invalid expire time in 'set' command\r\n")
+			}
+			unit := time.Millisecond
+			if strings.ToUpper(args[i]) == "EX" {
+				unit = time.Second
+			}
+			expires = s.now().Add(time.Duration(n) * unit)
+			i++
+		default:
+			return []byte("-ERR syntax error\r\n")
+		}
+	}
+	_, exists := s.lookup(key)
+	if (nx && exists) || (xx && !exists) {
+		return []byte("$-1\r\n")
+	}
+	s.data[key] = entry{value: value, expires: expires}
+	return []byte("+OK\r\n")
+}
+
+// compareAndRun is the only kind of script EVAL understands: run a command on
+// KEYS[1] with some of ARGV if the key holds ARGV[1], or return 0.
+var compareAndRun = regexp.MustCompile(`^if redis\.call\("GET",KEYS\[1\]\)==ARGV\[1\] then return redis\.call\("(\w+)",KEYS\[1\]((?:,ARGV\[\d+\])*)\) end return 0$`)
+
+var argvRef = regexp.MustCompile(`ARGV\[(\d+)\]`)
+
+// eval runs the script atomically, since s.mu is held throughout.
+func (s *Server) eval(args []string) []byte {
+	if len(args) < 2 {
+		return wrongArgs("EVAL")
+	}
+	numKeys, err := strconv.Atoi(args[1])
+	if err != nil || numKeys != 1 || len(args) < 3 {
+		return []byte("-ERR scripts must take exactly one key\r\n")
+	}
+	match := compareAndRun.FindStringSubmatch(args[0])
+	if match == nil {
+		return []byte("-ERR unsupported script\r\n")
+	}
+	key, argv := args[2], args[3:]
+	if len(argv) == 0 {
+		return []byte("-ERR script refers to a missing argument\r\n")
+	}
+	command := []string{key}
+	for _, ref := range argvRef.FindAllStringSubmatch(match[2], -1) {
+		i, _ := strconv.Atoi(ref[1])
+		if i < 1 || i > len(argv) {
+			return []byte("-ERR script refers to a missing argument\r\n")
+		}
+		command = append(command, argv[i-1])
+	}
+	if value, ok := s.lookup(key); !ok || value != argv[0] {
+		return integer(0)
+	}
+	return s.run(strings.ToUpper(match[1]), command)
+}
+
+// scan returns every matching key in a single batch.
+func (s *Server) scan(args []string) []byte {
+	pattern := "*"
+	for i := 1; i+1 < len(args); i += 2 {
+		if strings.ToUpper(args[i]) == "MATCH" {
+			pattern = args[i+1]
+		}
+	}
+	var keys []string
+	for key := range s.data {
+		if _, ok := s.lookup(key); !ok {
+			continue
+		}
+		if matched, _ := path.Match(pattern, key); matched {
+			keys = append(keys, key)
+		}
+	}
+	reply := array(2)
+	reply = append(reply, bulk("0", true)...)
+	reply = append(reply, array(len(keys))...)
+	for _, key := range keys {
+		reply = append(reply, bulk(key, true)...)
+	}
+	return reply
+}
+
+// lookup must be called with s.mu held; it drops expired keys.
+func (s *Server) lookup(key string) (string, bool) {
+	e, ok := s.data[key]
+	if !ok {
+		return "", false
+	}
+	if !e.expires.IsZero() && !s.now().Before(e.expires) {
+		delete(s.data, key)
+		return "", false
+	}
+	return e.value, true
+}
+
+func (s *Server) now() time.Time {
+	return time.Now().Add(s.offset)
+}
+
+func wrongArgs(name string) []byte {
+	return []byte(fmt.Sprintf("-ERR wrong number of arguments for '%s' command\r\n", strings.ToLower(name)))
+}
+
+func integer(n int) []byte {
+	return []byte(":" + strconv.Itoa(n) + "\r\n")
+}
+
+func array(n int) []byte {
+	return []byte("*" + strconv.Itoa(n) + "\r\n")
+}
+
+func bulk(value string, ok bool) []byte {
+	if !ok {
+		return []byte("$-1\r\n")
+	}
+	return []byte("$" + strconv.Itoa(len(value)) + "\r\n" + value + "\r\n")
+}
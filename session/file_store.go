@@ -0,0 +1,47 @@
+package session
+
+import (
+	"charge-monitor/journal"
+	"encoding/json"
+	"log/slog"
+	"time"
+)
+
+// FileStore is a MemoryStore whose sessions are journaled to a file. Expired
+// sessions are dropped from the file when the journal is compacted.
+type FileStore struct {
+	*MemoryStore
+	journal *journal.Mirror
+}
+
+func NewFileStore(path string, retention time.Duration) (*FileStore, error) {
+	memory := NewMemoryStore(retention)
+	j, err := journal.OpenMirror(path, func(line []byte) error {
+		var session Session
+		if err := json.Unmarshal(line, &session); err != nil {
+			return err
+		}
+		memory.Append(session)
+		return nil
+	}, memory.len, memory.snapshot)
+	if err != nil {
+		return nil, err
+	}
+	if err := j.CompactIfNeeded(); err != nil {
+		slog.Error("Failed to compact session journal", "error", err)
+	}
+	return &FileStore{MemoryStore: memory, journal: j}, nil
+}
+
+func (s *FileStore) Append(session Session) {
+	err := s.journal.Append(session, func() {
+		s.MemoryStore.Append(session)
+	})
+	if err != nil {
+		slog.Error("Failed to write session journal", "outletId", session.OutletID, "error", err)
+	}
+}
+
+func (s *FileStore) Close() error {
+	return s.journal.Close()
+}
@@ -0,0 +1,144 @@
+// Package session infers charging sessions from the samples of each outlet
+// and keeps the finished ones.
+package session
+
+import (
+	"charge-monitor/cache"
+	"charge-monitor/history"
+	"charge-monitor/tariff"
+	"cmp"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Session is the time an outlet was occupied, from plugging in to unplugging.
+type Session struct {
+	ID        string `json:"id"`
+	OutletID  string `json:"outlet_id"`
+	StationID string `json:"station_id,omitempty"`
+	// Start is backdated by the used minutes of the first sample, so a
+	// session already running when it is first seen still starts on time.
+	Start int64 `json:"start"`
+	// End is the time of the last sample of the session, or of the latest
+	// one while it is ongoing.
+	End             int64   `json:"end"`
+	Ongoing         bool    `json:"ongoing,omitempty"`
+	DurationSeconds int64   `json:"duration_seconds"`
+	UsedMinutes     int64   `json:"used_minutes"`
+	PeakWatts       float64 `json:"peak_watts"`
+	// AverageWatts is the time-weighted average power over the sampled span.
+	AverageWatts float64 `json:"average_watts"`
+	// EnergyKWh integrates the sampled power over time.
+	EnergyKWh float64 `json:"energy_kwh"`
+	// Cost is estimated with the tariff of the station, if it has one.
+	Cost     float64 `json:"cost,omitempty"`
+	Currency string  `json:"currency,omitempty"`
+
+	meter tariff.Meter
+}
+
+// Active reports whether a sample belongs to a session: a vehicle that
+// finished charging still occupies the outlet.
+func Active(s history.Sample) bool {
+	return s.State == cache.StateCharging || s.State == cache.StateFinished
+}
+
+// unsure reports whether a sample says nothing about the outlet being
+// occupied, as when it is offline for a moment.
+func unsure(s history.Sample) bool {
+	return s.State == cache.StateOffline || s.State == cache.StateUnknown
+}
+
+// Tracker turns the samples of each outlet into sessions. A session ends at
+// the first idle sample, or when the used minutes go down, which means the
+// vehicle was replaced between two samples. Offline and unknown samples keep
+// it open, unless there has been no charging or finished sample for longer
+// than maxGap.
+type Tracker struct {
+	store Store
+	// tariffs returns the tariff of a station, or nil if it has none.
+	tariffs func(stationId string) *tariff.Tariff
+	// maxGap caps how long a sample counts towards energy, see tariff.Meter.
+	maxGap time.Duration
+	mu     sync.Mutex
+	open   map[string]*Session
+	// ended is the end of the last finished session of each outlet; the next
+	// one is not backdated past it, so sessions neither overlap nor share IDs.
+	ended map[string]int64
+}
+
+func NewTracker(store Store, tariffs func(stationId string) *tariff.Tariff, maxGap time.Duration) *Tracker {
+	return &Tracker{store: store, tariffs: tariffs, maxGap: maxGap, open: make(map[string]*Session), ended: make(map[string]int64)}
+}
+
+// Observe feeds the next sample of an outlet; samples of one outlet must come
+// in time order.
+func (t *Tracker) Observe(outletId, stationId string, sample history.Sample) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	open := t.open[outletId]
+	if open != nil && unsure(sample) {
+		if t.maxGap <= 0 || sample.Time-open.End <= int64(t.maxGap/time.Second) {
+			return
+		}
+	}
+	if open != nil && (!Active(sample) || sample.UsedMinutes < open.UsedMinutes) {
+		delete(t.open, outletId)
+		t.ended[outletId] = open.End
+		t.store.Append(open.finish())
+		open = nil
+	}
+	if !Active(sample) {
+		return
+	}
+	if open == nil {
+		start := sample.Time - sample.UsedMinutes*60
+		if ended, ok := t.ended[outletId]; ok && start <= ended {
+			start = ended + 1
+		}
+		open = &Session{
+			ID:        fmt.Sprintf("%s-%d", outletId, start),
+			OutletID:  outletId,
+			StationID: stationId,
+			Start:     start,
+		}
+		open.meter.MaxGap = t.maxGap
+		if t.tariffs != nil {
+			open.meter.Tariff = t.tariffs(stationId)
+		}
+		t.open[outletId] = open
+	}
+	open.meter.Add(sample.Time, sample.Watts)
+	open.End = sample.Time
+	open.UsedMinutes = sample.UsedMinutes
+	open.PeakWatts = max(open.PeakWatts, sample.Watts)
+}
+
+// finish returns a copy of s with its derived fields filled in.
+func (s *Session) finish() Session {
+	finished := *s
+	finished.DurationSeconds = finished.End - finished.Start
+	finished.AverageWatts = s.meter.AverageWatts()
+	finished.EnergyKWh = s.meter.EnergyKWh()
+	if s.meter.Tariff != nil {
+		finished.Cost = s.meter.Cost
+		finished.Currency = s.meter.Tariff.Currency
+	}
+	return finished
+}
+
+// Ongoing returns the sessions that have not ended yet, ordered by start.
+func (t *Tracker) Ongoing() []Session {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	sessions := make([]Session, 0, len(t.open))
+	for _, open := range t.open {
+		session := open.finish()
+		session.Ongoing = true
+		sessions = append(sessions, session)
+	}
+	slices.SortFunc(sessions, func(a, b Session) int { return cmp.Compare(a.Start, b.Start) })
+	return sessions
+}
@@ -0,0 +1,183 @@
+package session
+
+import (
+	"charge-monitor/cache"
+	"charge-monitor/history"
+	"charge-monitor/tariff"
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTracker_Session(t *testing.T) {
+	store := NewMemoryStore(0)
+	tracker := NewTracker(store, nil, 0)
+	samples := []history.Sample{
+		{Time: 900, State: cache.StateIdle},
+		{Time: 1200, Watts: 100, UsedMinutes: 5, State: cache.StateCharging},
+		{Time: 1800, Watts: 200, UsedMinutes: 15, State: cache.StateCharging},
+		{Time: 2400, Watts: 0, UsedMinutes: 25, State: cache.StateFinished},
+		{Time: 3000, State: cache.StateIdle},
+	}
+	for _, sample := range samples[:4] {
+		tracker.Observe("outlet-1", "xzy-4", sample)
+	}
+	if ongoing := tracker.Ongoing(); len(ongoing) != 1 || !ongoing[0].Ongoing {
+		t.Fatalf("Expected one ongoing session, got %+v", ongoing)
+	}
+	tracker.Observe("outlet-1", "xzy-4", samples[4])
+
+	if ongoing := tracker.Ongoing(); len(ongoing) != 0 {
+		t.Errorf("Expected no ongoing session after going idle, got %+v", ongoing)
+	}
+	sessions := store.Range(0, 5000)
+	if len(sessions) != 1 {
+		t.Fatalf("Expected 1 session, got %d", len(sessions))
+	}
+	s := sessions[0]
+	// The first sample is 5 minutes in, so the session started at 900.
+	if s.Start != 900 || s.End != 2400 || s.DurationSeconds != 1500 {
+		t.Errorf("Unexpected session span %+v", s)
+	}
+	if s.StationID != "xzy-4" || s.UsedMinutes != 25 || s.PeakWatts != 200 {
+		t.Errorf("Unexpected session %+v", s)
+	}
+	// (100+200)/2*600 + (200+0)/2*600 = 150000 Ws over 1200 s.
+	if s.AverageWatts != 125 {
+		t.Errorf("Expected average 125W, got %v", s.AverageWatts)
+	}
+	if want := 150000 / 3.6e6; s.EnergyKWh != want {
+		t.Errorf("Expected %v kWh, got %v", want, s.EnergyKWh)
+	}
+}
+
+func TestTracker_SplitsOnUsedMinutesReset(t *testing.T) {
+	store := NewMemoryStore(0)
+	tracker := NewTracker(store, nil, 0)
+	tracker.Observe("outlet-1", "", history.Sample{Time: 1000, Watts: 90, UsedMinutes: 40, State: cache.StateCharging})
+	tracker.Observe("outlet-1", "", history.Sample{Time: 1600, Watts: 90, UsedMinutes: 2, State: cache.StateCharging})
+
+	if sessions := store.Range(0, 5000); len(sessions) != 1 || sessions[0].End != 1000 {
+		t.Errorf("Expected the first session to end when used minutes went down, got %+v", sessions)
+	}
+	if ongoing := tracker.Ongoing(); len(ongoing) != 1 || ongoing[0].Start != 1480 {
+		t.Errorf("Expected a new session starting at 1480, got %+v", ongoing)
+	}
+}
+
+func TestTracker_OfflineBlip(t *testing.T) {
+	store := NewMemoryStore(0)
+	tracker := NewTracker(store, nil, 15*time.Minute)
+	tracker.Observe("outlet-1", "", history.Sample{Time: 1000000, Watts: 90, UsedMinutes: 40, State: cache.StateCharging})
+	tracker.Observe("outlet-1", "", history.Sample{Time: 1000150, State: cache.StateOffline})
+	tracker.Observe("outlet-1", "", history.Sample{Time: 1000300, Watts: 90, UsedMinutes: 45, State: cache.StateCharging})
+	tracker.Observe("outlet-1", "", history.Sample{Time: 1000600, State: cache.StateIdle})
+
+	sessions := store.Range(0, 2000000)
+	if len(sessions) != 1 {
+		t.Fatalf("Expected a single session across the offline sample, got %+v", sessions)
+	}
+	if s := sessions[0]; s.ID != "outlet-1-997600" || s.End != 1000300 || s.UsedMinutes != 45 {
+		t.Errorf("Unexpected session %+v", s)
+	}
+}
+
+func TestTracker_OfflineTimeout(t *testing.T) {
+	store := NewMemoryStore(0)
+	tracker := NewTracker(store, nil, 15*time.Minute)
+	tracker.Observe("outlet-1", "", history.Sample{Time: 1000000, Watts: 90, UsedMinutes: 40, State: cache.StateCharging})
+	tracker.Observe("outlet-1", "", history.Sample{Time: 1000600, State: cache.StateOffline})
+	if ongoing := tracker.Ongoing(); len(ongoing) != 1 {
+		t.Fatalf("Expected the session to stay open while briefly offline, got %+v", ongoing)
+	}
+	tracker.Observe("outlet-1", "", history.Sample{Time: 1001200, State: cache.StateOffline})
+	if sessions := store.Range(0, 2000000); len(sessions) != 1 || sessions[0].End != 1000000 {
+		t.Fatalf("Expected the session to end at its last charging sample, got %+v", sessions)
+	}
+
+	// The same vehicle is seen again, but its session has already been kept.
+	tracker.Observe("outlet-1", "", history.Sample{Time: 1001800, Watts: 90, UsedMinutes: 70, State: cache.StateCharging})
+	if ongoing := tracker.Ongoing(); len(ongoing) != 1 || ongoing[0].Start != 1000001 {
+		t.Errorf("Expected the next session to start after the previous one, got %+v", ongoing)
+	}
+}
+
+func TestTracker_CapsGaps(t *testing.T) {
+	tracker := NewTracker(NewMemoryStore(0), nil, 15*time.Minute)
+	tracker.Observe("outlet-1", "", history.Sample{Time: 0, Watts: 1000, UsedMinutes: 1, State: cache.StateCharging})
+	tracker.Observe("outlet-1", "", history.Sample{Time: 3600, Watts: 1000, UsedMinutes: 61, State: cache.StateCharging})
+
+	ongoing := tracker.Ongoing()
+	if len(ongoing) != 1 || math.Abs(ongoing[0].EnergyKWh-0.25) > 1e-9 {
+		t.Errorf("Expected an hour without samples to count for 15 minutes, got %+v", ongoing)
+	}
+}
+
+func TestTracker_Cost(t *testing.T) {
+	hourly, err := tariff.New("CNY", time.UTC, []tariff.Period{{Tiers: []tariff.Tier{{PerHour: 0.5}}}})
+	if err != nil {
+		t.Fatalf("tariff.New failed: %v", err)
+	}
+	store := NewMemoryStore(0)
+	tracker := NewTracker(store, func(stationId string) *tariff.Tariff {
+		if stationId == "xzy-4" {
+			return hourly
+		}
+		return nil
+	}, 0)
+	for _, outletId := range []string{"outlet-1", "outlet-2"} {
+		stationId := map[string]string{"outlet-1": "xzy-4"}[outletId]
+		tracker.Observe(outletId, stationId, history.Sample{Time: 0, Watts: 100, State: cache.StateCharging})
+		tracker.Observe(outletId, stationId, history.Sample{Time: 7200, Watts: 100, UsedMinutes: 120, State: cache.StateCharging})
+		tracker.Observe(outletId, stationId, history.Sample{Time: 7800, State: cache.StateIdle})
+	}
+
+	sessions := store.Range(0, 10000)
+	if len(sessions) != 2 {
+		t.Fatalf("Expected 2 sessions, got %+v", sessions)
+	}
+	if s := sessions[0]; s.Cost != 1 || s.Currency != "CNY" {
+		t.Errorf("Expected two hours to cost 1 CNY, got %v %s", s.Cost, s.Currency)
+	}
+	if s := sessions[1]; s.Cost != 0 || s.Currency != "" {
+		t.Errorf("Expected no cost without a tariff, got %v %s", s.Cost, s.Currency)
+	}
+}
+
+func TestMemoryStore_RangeAndRetention(t *testing.T) {
+	s := NewMemoryStore(time.Hour)
+	s.Append(Session{ID: "a", Start: 0, End: 1000})
+	s.Append(Session{ID: "b", Start: 2000, End: 3000})
+	s.Append(Session{ID: "c", Start: 4000, End: 5000})
+
+	// Sessions that overlap the range count, even if they started before it.
+	if sessions := s.Range(2500, 4000); len(sessions) != 1 || sessions[0].ID != "b" {
+		t.Errorf("Expected only session b in [2500, 4000), got %+v", sessions)
+	}
+	if sessions := s.Range(0, 10000); len(sessions) != 2 || sessions[0].ID != "b" {
+		t.Errorf("Expected session a to expire, got %+v", sessions)
+	}
+}
+
+func TestFileStore_SurvivesReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.jsonl")
+
+	s, err := NewFileStore(path, time.Hour)
+	if err != nil {
+		t.Fatalf("NewFileStore failed: %v", err)
+	}
+	s.Append(Session{ID: "outlet-1-900", OutletID: "outlet-1", Start: 900, End: 2400, EnergyKWh: 0.5})
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	reopened, err := NewFileStore(path, time.Hour)
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	defer reopened.Close()
+	if sessions := reopened.Range(0, 5000); len(sessions) != 1 || sessions[0].EnergyKWh != 0.5 {
+		t.Errorf("Expected the session to survive reopening, got %+v", sessions)
+	}
+}
@@ -0,0 +1,77 @@
+package session
+
+import (
+	"sync"
+	"time"
+)
+
+type Store interface {
+	// Append records a finished session; sessions are appended in order of End.
+	Append(s Session)
+	// Range returns the sessions that overlap [from, to), ordered by End.
+	Range(from, to int64) []Session
+	// Close releases the storage behind the store.
+	Close() error
+}
+
+// MemoryStore keeps sessions in memory and drops those that ended before the
+// retention window.
+type MemoryStore struct {
+	retention time.Duration
+	sessions  []Session
+	mu        sync.RWMutex
+}
+
+func NewMemoryStore(retention time.Duration) *MemoryStore {
+	return &MemoryStore{retention: retention}
+}
+
+func (s *MemoryStore) Append(session Session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions = append(s.sessions, session)
+	cutoff := session.End - int64(s.retention/time.Second)
+	if s.retention > 0 && s.sessions[0].End < cutoff {
+		expired := 0
+		for expired < len(s.sessions) && s.sessions[expired].End < cutoff {
+			expired++
+		}
+		// Copy so the backing array of expired sessions can be freed.
+		s.sessions = append([]Session(nil), s.sessions[expired:]...)
+	}
+}
+
+func (s *MemoryStore) Range(from, to int64) []Session {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var sessions []Session
+	for _, session := range s.sessions {
+		if session.Start < to && session.End >= from {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions
+}
+
+// len returns the number of sessions held.
+func (s *MemoryStore) len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.sessions)
+}
+
+// snapshot emits every session held.
+func (s *MemoryStore) snapshot(emit func(v any) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, session := range s.sessions {
+		if err := emit(session); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,55 @@
+package tariff
+
+import "time"
+
+// Meter integrates consecutive power samples into energy and, given a tariff,
+// cost. The power between two samples is taken to change linearly, unless
+// they are more than MaxGap apart.
+type Meter struct {
+	// Tariff prices the energy; nil to only measure it.
+	Tariff *Tariff
+	// MaxGap is how long a sample is taken to last without a newer one, as
+	// when polling stopped; zero for no limit. A longer stretch only counts
+	// the earlier sample's power for MaxGap.
+	MaxGap time.Duration
+	Cost   float64
+	// Seconds is the time covered by the samples so far.
+	Seconds int64
+
+	wattSeconds float64
+	started     bool
+	lastTime    int64
+	lastWatts   float64
+}
+
+// Add feeds the next sample; samples must come in time order.
+func (m *Meter) Add(at int64, watts float64) {
+	if m.started && at > m.lastTime {
+		end, average := at, (m.lastWatts+watts)/2
+		if maxGap := int64(m.MaxGap / time.Second); maxGap > 0 && at-m.lastTime > maxGap {
+			end, average = m.lastTime+maxGap, m.lastWatts
+		}
+		elapsed := end - m.lastTime
+		m.wattSeconds += average * float64(elapsed)
+		m.Seconds += elapsed
+		if m.Tariff != nil {
+			m.Cost += m.Tariff.Cost(m.lastTime, end, average)
+		}
+	}
+	m.started = true
+	m.lastTime = at
+	m.lastWatts = watts
+}
+
+// EnergyKWh is the energy drawn over the samples so far.
+func (m *Meter) EnergyKWh() float64 {
+	return m.wattSeconds / 3.6e6
+}
+
+// AverageWatts is the time-weighted average power over the samples so far.
+func (m *Meter) AverageWatts() float64 {
+	if m.Seconds == 0 {
+		return 0
+	}
+	return m.wattSeconds / float64(m.Seconds)
+}
@@ -0,0 +1,147 @@
+// Package tariff estimates what charging costs from power samples, with the
+// power-banded hourly rates chargers bill by and time-of-use periods.
+package tariff
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Tier is a power band of a period. The band of a draw is the first tier
+// whose MaxWatts is at least the power drawn, like the vendor's powerFee.
+type Tier struct {
+	// MaxWatts is the inclusive upper bound of the band; 0 leaves it open.
+	MaxWatts float64
+	// PerHour is charged for every hour spent in the band.
+	PerHour float64
+	// PerKWh is charged for every kWh drawn in the band.
+	PerKWh float64
+}
+
+// Period is the part of each day from Start until the next period starts.
+type Period struct {
+	// Start is the offset from local midnight.
+	Start time.Duration
+	Tiers []Tier
+}
+
+type Tariff struct {
+	Currency string
+	location *time.Location
+	periods  []Period
+}
+
+// New returns a tariff with the periods sorted by start. The periods wrap
+// around midnight, so the last one lasts until the first starts the next day.
+func New(currency string, location *time.Location, periods []Period) (*Tariff, error) {
+	if len(periods) == 0 {
+		return nil, errors.New("tariff needs at least one period")
+	}
+	periods = slices.Clone(periods)
+	slices.SortFunc(periods, func(a, b Period) int { return cmp.Compare(a.Start, b.Start) })
+	for i, period := range periods {
+		if period.Start < 0 || period.Start >= 24*time.Hour {
+			return nil, fmt.Errorf("period start %v is not within a day", period.Start)
+		}
+		if i > 0 && period.Start == periods[i-1].Start {
+			return nil, fmt.Errorf("two periods start at %v", period.Start)
+		}
+		if len(period.Tiers) == 0 {
+			return nil, fmt.Errorf("period starting at %v has no tiers", period.Start)
+		}
+		tiers := slices.Clone(period.Tiers)
+		// Open bands sort last.
+		slices.SortStableFunc(tiers, func(a, b Tier) int {
+			switch {
+			case a.MaxWatts == b.MaxWatts:
+				return 0
+			case a.MaxWatts == 0:
+				return 1
+			case b.MaxWatts == 0:
+				return -1
+			}
+			return cmp.Compare(a.MaxWatts, b.MaxWatts)
+		})
+		for _, tier := range tiers {
+			if tier.MaxWatts < 0 || tier.PerHour < 0 || tier.PerKWh < 0 {
+				return nil, fmt.Errorf("period starting at %v has a negative tier", period.Start)
+			}
+		}
+		periods[i].Tiers = tiers
+	}
+	if location == nil {
+		location = time.Local
+	}
+	return &Tariff{Currency: currency, location: location, periods: periods}, nil
+}
+
+// Location is the time zone the periods are in.
+func (t *Tariff) Location() *time.Location {
+	return t.location
+}
+
+// ParseTimeOfDay parses an "HH:MM" time of day into its offset from midnight.
+func ParseTimeOfDay(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("expected HH:MM, got %q", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Cost is the price of drawing watts from from to to, in Unix seconds. An
+// interval that crosses into another period is priced piecewise.
+func (t *Tariff) Cost(from, to int64, watts float64) float64 {
+	var cost float64
+	for from < to {
+		period, end := t.periodAt(from)
+		end = min(end, to)
+		tier := period.tier(watts)
+		hours := float64(end-from) / 3600
+		cost += tier.PerHour*hours + tier.PerKWh*watts/1000*hours
+		from = end
+	}
+	return cost
+}
+
+// periodAt returns the period in effect at the Unix time at and the Unix
+// time it ends.
+func (t *Tariff) periodAt(at int64) (Period, int64) {
+	local := time.Unix(at, 0).In(t.location)
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, t.location)
+	offset := local.Sub(midnight)
+	next := nextPeriod(t.periods, offset)
+	current := t.periods[(next+len(t.periods)-1)%len(t.periods)]
+	var end time.Time
+	if next < len(t.periods) {
+		end = midnight.Add(t.periods[next].Start)
+	} else {
+		end = time.Date(local.Year(), local.Month(), local.Day()+1, 0, 0, 0, 0, t.location).Add(t.periods[0].Start)
+	}
+	// Guard against daylight saving time folding the boundary behind at.
+	return current, max(end.Unix(), at+1)
+}
+
+// nextPeriod returns the index of the first period starting after offset.
+func nextPeriod(periods []Period, offset time.Duration) int {
+	i, found := slices.BinarySearchFunc(periods, offset, func(p Period, offset time.Duration) int {
+		return cmp.Compare(p.Start, offset)
+	})
+	if found {
+		i++
+	}
+	return i
+}
+
+func (p Period) tier(watts float64) Tier {
+	for _, tier := range p.Tiers {
+		if tier.MaxWatts == 0 || watts <= tier.MaxWatts {
+			return tier
+		}
+	}
+	// Draws above every band are billed at the highest one.
+	return p.Tiers[len(p.Tiers)-1]
+}
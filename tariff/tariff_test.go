@@ -0,0 +1,117 @@
+package tariff
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func newTestTariff(t *testing.T) *Tariff {
+	t.Helper()
+	tiers := []Tier{{PerHour: 1}, {MaxWatts: 200, PerHour: 0.25}, {MaxWatts: 500, PerHour: 0.5}}
+	tariff, err := New("CNY", time.UTC, []Period{
+		{Start: 22 * time.Hour, Tiers: []Tier{{PerHour: 0.5}, {MaxWatts: 200, PerHour: 0.125}}},
+		{Start: 8 * time.Hour, Tiers: tiers},
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	return tariff
+}
+
+func almostEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestTariff_CostByTier(t *testing.T) {
+	tariff := newTestTariff(t)
+	noon := int64(12 * 3600)
+	tests := []struct {
+		watts float64
+		want  float64
+	}{
+		{88, 0.25},
+		{200, 0.25},
+		{350, 0.5},
+		{1200, 1},
+	}
+	for _, tt := range tests {
+		if got := tariff.Cost(noon, noon+3600, tt.watts); !almostEqual(got, tt.want) {
+			t.Errorf("Expected an hour at %vW to cost %v, got %v", tt.watts, tt.want, got)
+		}
+	}
+}
+
+func TestTariff_CostAcrossPeriods(t *testing.T) {
+	tariff := newTestTariff(t)
+	// 21:00 to 23:00 is one hour of day rate and one of night rate; the
+	// night period wraps past midnight until 08:00.
+	if got := tariff.Cost(21*3600, 23*3600, 100); !almostEqual(got, 0.25+0.125) {
+		t.Errorf("Expected 0.375, got %v", got)
+	}
+	if got := tariff.Cost(3*3600, 4*3600, 100); !almostEqual(got, 0.125) {
+		t.Errorf("Expected the night rate after midnight, got %v", got)
+	}
+}
+
+func TestTariff_PerKWh(t *testing.T) {
+	tariff, err := New("CNY", time.UTC, []Period{{Tiers: []Tier{{PerKWh: 0.6}}}})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if got := tariff.Cost(0, 2*3600, 500); !almostEqual(got, 0.6) {
+		t.Errorf("Expected 1 kWh to cost 0.6, got %v", got)
+	}
+}
+
+func TestNew_Invalid(t *testing.T) {
+	tests := map[string][]Period{
+		"no periods":      nil,
+		"no tiers":        {{Start: 0}},
+		"duplicate start": {{Start: time.Hour, Tiers: []Tier{{}}}, {Start: time.Hour, Tiers: []Tier{{}}}},
+		"past midnight":   {{Start: 25 * time.Hour, Tiers: []Tier{{}}}},
+		"negative price":  {{Tiers: []Tier{{PerHour: -1}}}},
+	}
+	for name, periods := range tests {
+		if _, err := New("", nil, periods); err == nil {
+			t.Errorf("Expected %s to be rejected", name)
+		}
+	}
+}
+
+func TestParseTimeOfDay(t *testing.T) {
+	if d, err := ParseTimeOfDay("07:30"); err != nil || d != 7*time.Hour+30*time.Minute {
+		t.Errorf("Expected 7h30m, got %v, %v", d, err)
+	}
+	if _, err := ParseTimeOfDay("7pm"); err == nil {
+		t.Error("Expected an invalid time of day to be rejected")
+	}
+}
+
+func TestMeter(t *testing.T) {
+	m := Meter{Tariff: newTestTariff(t)}
+	noon := int64(12 * 3600)
+	m.Add(noon, 100)
+	m.Add(noon+1800, 300)
+	m.Add(noon+3600, 100)
+
+	// Both halves average 200W, which is in the 0.25 per hour band.
+	if !almostEqual(m.EnergyKWh(), 0.2) || !almostEqual(m.AverageWatts(), 200) {
+		t.Errorf("Expected 0.2 kWh at 200W, got %v kWh at %vW", m.EnergyKWh(), m.AverageWatts())
+	}
+	if !almostEqual(m.Cost, 0.25) || m.Seconds != 3600 {
+		t.Errorf("Expected 0.25 over 3600s, got %v over %ds", m.Cost, m.Seconds)
+	}
+}
+
+func TestMeter_MaxGap(t *testing.T) {
+	m := Meter{MaxGap: 15 * time.Minute}
+	m.Add(0, 1000)
+	m.Add(600, 1000)
+	// Polling stopped for two hours; the 1000W sample only lasts 15 minutes.
+	m.Add(600+7200, 0)
+
+	if !almostEqual(m.EnergyKWh(), 1000*(600+900)/3.6e6) || m.Seconds != 1500 {
+		t.Errorf("Expected 1500s at 1000W, got %v kWh over %ds", m.EnergyKWh(), m.Seconds)
+	}
+}
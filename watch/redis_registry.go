@@ -0,0 +1,194 @@
+package watch
+
+import (
+	"charge-monitor/redis"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RedisRegistry keeps each watch under its own key in a Redis-compatible
+// server, so every replica can read, cancel and fire it. Keys are named
+// prefix+kind+":"+subject+":"+id and expire with their watch. A watch is taken
+// by whichever replica deletes its key, so it fires once.
+type RedisRegistry struct {
+	client  *redis.Client
+	prefix  string
+	limit   int
+	timeout time.Duration
+}
+
+// NewRedisRegistry holds up to limit watches at a time.
+func NewRedisRegistry(client *redis.Client, prefix string, limit int) *RedisRegistry {
+	return &RedisRegistry{client: client, prefix: prefix, limit: limit, timeout: 5 * time.Second}
+}
+
+func (r *RedisRegistry) Add(w Watch, now time.Time) (Watch, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+	// Concurrent additions may overshoot the limit slightly.
+	keys, err := r.scan(ctx, r.prefix+"*")
+	if err != nil {
+		return Watch{}, err
+	}
+	if len(keys) >= r.limit {
+		return Watch{}, ErrFull
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	w.ID = hex.EncodeToString(id)
+	w.CreatedAt = now.Unix()
+	value, err := json.Marshal(w)
+	if err != nil {
+		return Watch{}, err
+	}
+	ttl := max(time.Unix(w.ExpiresAt, 0).Sub(now), time.Millisecond)
+	_, err = r.client.Do(ctx, "SET", r.key(w), string(value), "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	if err != nil {
+		return Watch{}, err
+	}
+	return w, nil
+}
+
+func (r *RedisRegistry) Get(id string, now time.Time) (Watch, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+	key, ok := r.find(ctx, id)
+	if !ok {
+		return Watch{}, false
+	}
+	value, ok, err := r.client.String(ctx, "GET", key)
+	if err != nil {
+		slog.Error("Failed to read watch from redis", "watch", id, "error", err)
+		return Watch{}, false
+	}
+	if !ok {
+		return Watch{}, false
+	}
+	var w Watch
+	if err := json.Unmarshal([]byte(value), &w); err != nil {
+		slog.Error("Failed to decode watch from redis", "watch", id, "error", err)
+		return Watch{}, false
+	}
+	if w.expired(now) {
+		return Watch{}, false
+	}
+	return w, true
+}
+
+func (r *RedisRegistry) Remove(id string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+	key, ok := r.find(ctx, id)
+	if !ok {
+		return false
+	}
+	return r.claim(ctx, key)
+}
+
+func (r *RedisRegistry) Take(kind, subject string, now time.Time) []Watch {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+	keys, err := r.scan(ctx, r.prefix+kind+":"+escapeGlob(subject)+":*")
+	if err != nil {
+		slog.Error("Failed to look up watches in redis", "kind", kind, "subject", subject, "error", err)
+		return nil
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	reply, err := r.client.Do(ctx, append([]string{"MGET"}, keys...)...)
+	if err != nil {
+		slog.Error("Failed to read watches from redis", "kind", kind, "subject", subject, "error", err)
+		return nil
+	}
+	values, _ := reply.([]any)
+	var taken []Watch
+	for i, value := range values {
+		s, ok := value.(string)
+		if !ok {
+			// Expired or taken by another replica in the meantime.
+			continue
+		}
+		var w Watch
+		if err := json.Unmarshal([]byte(s), &w); err != nil {
+			slog.Error("Failed to decode watch from redis", "key", keys[i], "error", err)
+			continue
+		}
+		if r.claim(ctx, keys[i]) && !w.expired(now) {
+			taken = append(taken, w)
+		}
+	}
+	return taken
+}
+
+func (r *RedisRegistry) Close() error {
+	return r.client.Close()
+}
+
+func (r *RedisRegistry) key(w Watch) string {
+	return r.prefix + w.Kind + ":" + w.subject() + ":" + w.ID
+}
+
+// find returns the key of the watch with the given ID.
+func (r *RedisRegistry) find(ctx context.Context, id string) (string, bool) {
+	keys, err := r.scan(ctx, r.prefix+"*:"+escapeGlob(id))
+	if err != nil {
+		slog.Error("Failed to look up watch in redis", "watch", id, "error", err)
+		return "", false
+	}
+	if len(keys) == 0 {
+		return "", false
+	}
+	return keys[0], true
+}
+
+// claim deletes key, reporting whether this call was the one to delete it.
+func (r *RedisRegistry) claim(ctx context.Context, key string) bool {
+	deleted, err := r.client.Do(ctx, "DEL", key)
+	if err != nil {
+		slog.Error("Failed to delete watch from redis", "key", key, "error", err)
+		return false
+	}
+	return deleted == int64(1)
+}
+
+func (r *RedisRegistry) scan(ctx context.Context, pattern string) ([]string, error) {
+	var keys []string
+	cursor := "0"
+	for {
+		reply, err := r.client.Do(ctx, "SCAN", cursor, "MATCH", pattern, "COUNT", "500")
+		if err != nil {
+			return nil, err
+		}
+		page, _ := reply.([]any)
+		if len(page) != 2 {
+			return nil, redis.Error("unexpected SCAN reply")
+		}
+		cursor, _ = page[0].(string)
+		batch, _ := page[1].([]any)
+		for _, key := range batch {
+			keys = append(keys, key.(string))
+		}
+		if cursor == "0" {
+			return keys, nil
+		}
+	}
+}
+
+// escapeGlob quotes the characters SCAN MATCH treats specially.
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`*?[]\`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,69 @@
+package watch
+
+import (
+	"charge-monitor/redis"
+	"charge-monitor/redis/redistest"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTestRedisRegistry(t *testing.T, server *redistest.Server, limit int) *RedisRegistry {
+	r := NewRedisRegistry(redis.NewClient(redis.Options{Address: server.Addr()}), "charge-monitor:watch:", limit)
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+func TestRedisRegistry_SharedBetweenReplicas(t *testing.T) {
+	server, err := redistest.NewServer("")
+	if err != nil {
+		t.Fatalf("Failed to start redis server: %v", err)
+	}
+	defer server.Close()
+	a, b := newTestRedisRegistry(t, server, 10), newTestRedisRegistry(t, server, 10)
+	now := time.Now()
+
+	station, _ := a.Add(Watch{Kind: KindStationFree, StationID: "xzy-4", ExpiresAt: now.Add(time.Hour).Unix()}, now)
+	outlet, _ := a.Add(Watch{Kind: KindOutletFinished, OutletID: "outlet-1", ExpiresAt: now.Add(time.Hour).Unix()}, now)
+	if got, ok := b.Get(station.ID, now); !ok || got != station {
+		t.Errorf("Expected the other replica to read %+v, got %+v", station, got)
+	}
+	if taken := b.Take(KindStationFree, "outlet-1", now); len(taken) != 0 {
+		t.Errorf("Expected only watches of the same kind to be taken, got %+v", taken)
+	}
+	taken := b.Take(KindStationFree, "xzy-4", now)
+	if len(taken) != 1 || taken[0].ID != station.ID {
+		t.Fatalf("Expected the station watch, got %+v", taken)
+	}
+	if taken := a.Take(KindStationFree, "xzy-4", now); len(taken) != 0 {
+		t.Errorf("Expected watches to fire once across replicas, got %+v", taken)
+	}
+	if !b.Remove(outlet.ID) {
+		t.Error("Expected the other replica to remove the watch")
+	}
+	if _, ok := a.Get(outlet.ID, now); ok {
+		t.Error("Expected a removed watch to be gone")
+	}
+}
+
+func TestRedisRegistry_Expiry(t *testing.T) {
+	server, err := redistest.NewServer("")
+	if err != nil {
+		t.Fatalf("Failed to start redis server: %v", err)
+	}
+	defer server.Close()
+	r := newTestRedisRegistry(t, server, 1)
+	now := time.Now()
+	w, _ := r.Add(Watch{Kind: KindStationFree, StationID: "xzy-4", ExpiresAt: now.Add(time.Minute).Unix()}, now)
+
+	if _, err := r.Add(Watch{Kind: KindStationFree, StationID: "xzy-4", ExpiresAt: now.Add(time.Minute).Unix()}, now); !errors.Is(err, ErrFull) {
+		t.Errorf("Expected ErrFull, got %v", err)
+	}
+	server.FastForward(2 * time.Minute)
+	if _, ok := r.Get(w.ID, now); ok {
+		t.Error("Expected an expired watch to be gone")
+	}
+	if _, err := r.Add(Watch{Kind: KindStationFree, StationID: "xzy-4", ExpiresAt: now.Add(time.Minute).Unix()}, now); err != nil {
+		t.Errorf("Expected expired watches to make room, got %v", err)
+	}
+}
@@ -0,0 +1,135 @@
+// Package watch keeps one-shot requests to be told when a station has a free
+// outlet or an outlet finished charging.
+package watch
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrFull = errors.New("too many watches")
+
+const (
+	// KindStationFree fires when an outlet of StationID becomes free.
+	KindStationFree = "station_free"
+	// KindOutletFinished fires when OutletID finishes charging.
+	KindOutletFinished = "outlet_finished"
+)
+
+type Watch struct {
+	// ID is random, so knowing it is what allows reading or cancelling it.
+	ID        string `json:"id"`
+	Kind      string `json:"kind"`
+	StationID string `json:"station_id,omitempty"`
+	OutletID  string `json:"outlet_id,omitempty"`
+	Channel   string `json:"channel"`
+	Target    string `json:"target"`
+	CreatedAt int64  `json:"created_at"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+func (w Watch) expired(now time.Time) bool {
+	return now.Unix() >= w.ExpiresAt
+}
+
+// subject is the station or outlet the watch waits on.
+func (w Watch) subject() string {
+	if w.Kind == KindOutletFinished {
+		return w.OutletID
+	}
+	return w.StationID
+}
+
+// Store keeps watches until they fire, are removed or expire.
+type Store interface {
+	// Add assigns w an ID and stores it, or returns ErrFull.
+	Add(w Watch, now time.Time) (Watch, error)
+	Get(id string, now time.Time) (Watch, bool)
+	Remove(id string) bool
+	// Take removes and returns the live watches of kind on subject, a
+	// station or outlet ID. Each watch is taken once, however many callers
+	// race for it.
+	Take(kind, subject string, now time.Time) []Watch
+	Close() error
+}
+
+// Registry holds watches in memory. Expired watches are dropped lazily.
+type Registry struct {
+	mu      sync.Mutex
+	watches map[string]Watch
+	limit   int
+}
+
+// NewRegistry holds up to limit watches at a time.
+func NewRegistry(limit int) *Registry {
+	return &Registry{watches: make(map[string]Watch), limit: limit}
+}
+
+// Add assigns w an ID and stores it.
+func (r *Registry) Add(w Watch, now time.Time) (Watch, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.watches) >= r.limit {
+		r.expire(now)
+		if len(r.watches) >= r.limit {
+			return Watch{}, ErrFull
+		}
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	w.ID = hex.EncodeToString(id)
+	w.CreatedAt = now.Unix()
+	r.watches[w.ID] = w
+	return w, nil
+}
+
+func (r *Registry) Get(id string, now time.Time) (Watch, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	w, ok := r.watches[id]
+	if ok && w.expired(now) {
+		delete(r.watches, id)
+		return Watch{}, false
+	}
+	return w, ok
+}
+
+func (r *Registry) Remove(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.watches[id]
+	delete(r.watches, id)
+	return ok
+}
+
+func (r *Registry) Take(kind, subject string, now time.Time) []Watch {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var taken []Watch
+	for id, w := range r.watches {
+		if w.Kind != kind || w.subject() != subject {
+			continue
+		}
+		delete(r.watches, id)
+		if !w.expired(now) {
+			taken = append(taken, w)
+		}
+	}
+	return taken
+}
+
+func (r *Registry) Close() error {
+	return nil
+}
+
+// expire must be called with mu held.
+func (r *Registry) expire(now time.Time) {
+	for id, w := range r.watches {
+		if w.expired(now) {
+			delete(r.watches, id)
+		}
+	}
+}
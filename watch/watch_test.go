@@ -0,0 +1,58 @@
+package watch
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRegistry_TakeFiresOnce(t *testing.T) {
+	r := NewRegistry(10)
+	now := time.Now()
+	first, _ := r.Add(Watch{Kind: KindStationFree, StationID: "xzy-4", ExpiresAt: now.Add(time.Hour).Unix()}, now)
+	r.Add(Watch{Kind: KindStationFree, StationID: "xzy-4", ExpiresAt: now.Add(-time.Second).Unix()}, now)
+	other, _ := r.Add(Watch{Kind: KindStationFree, StationID: "xzct-1", ExpiresAt: now.Add(time.Hour).Unix()}, now)
+
+	if first.ID == "" || first.ID == other.ID || first.CreatedAt != now.Unix() {
+		t.Errorf("Expected unique IDs and a creation time, got %+v and %+v", first, other)
+	}
+	taken := r.Take(KindStationFree, "xzy-4", now)
+	if len(taken) != 1 || taken[0].ID != first.ID {
+		t.Fatalf("Expected only the live watch of the station, got %+v", taken)
+	}
+	if taken := r.Take(KindStationFree, "xzy-4", now); len(taken) != 0 {
+		t.Errorf("Expected watches to fire once, got %+v", taken)
+	}
+	if _, ok := r.Get(other.ID, now); !ok {
+		t.Error("Expected the watch of another station to remain")
+	}
+}
+
+func TestRegistry_Expiry(t *testing.T) {
+	r := NewRegistry(1)
+	now := time.Now()
+	w, _ := r.Add(Watch{StationID: "xzy-4", ExpiresAt: now.Add(time.Minute).Unix()}, now)
+
+	if _, err := r.Add(Watch{StationID: "xzy-4"}, now); !errors.Is(err, ErrFull) {
+		t.Errorf("Expected ErrFull, got %v", err)
+	}
+	later := now.Add(2 * time.Minute)
+	if _, ok := r.Get(w.ID, later); ok {
+		t.Error("Expected an expired watch to be gone")
+	}
+	if _, err := r.Add(Watch{StationID: "xzy-4", ExpiresAt: later.Add(time.Minute).Unix()}, later); err != nil {
+		t.Errorf("Expected expired watches to make room, got %v", err)
+	}
+}
+
+func TestRegistry_Remove(t *testing.T) {
+	r := NewRegistry(10)
+	now := time.Now()
+	w, _ := r.Add(Watch{StationID: "xzy-4", ExpiresAt: now.Add(time.Hour).Unix()}, now)
+	if !r.Remove(w.ID) {
+		t.Error("Expected the watch to be removed")
+	}
+	if r.Remove(w.ID) {
+		t.Error("Expected a second removal to fail")
+	}
+}
@@ -0,0 +1,479 @@
+// Package webhook delivers signed JSON notifications of outlet changes to
+// subscribed HTTP endpoints, retrying failures and recording deliveries that
+// cannot be made in a dead-letter log.
+package webhook
+
+import (
+	"bytes"
+	"charge-monitor/cache"
+	"charge-monitor/journal"
+	"cmp"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	mathrand "math/rand/v2"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNotFound = errors.New("webhook not found")
+	ErrExists   = errors.New("webhook already exists")
+	// ErrStatic is returned when removing a webhook defined in the config file.
+	ErrStatic = errors.New("webhook is defined in the config file")
+)
+
+// DefaultEvents are delivered to subscriptions that do not list any.
+var DefaultEvents = []cache.EventType{cache.EventBecameFree, cache.EventFinishedCharging}
+
+type Subscription struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+	// Secret signs the payloads; empty sends them unsigned.
+	Secret string            `json:"secret,omitempty"`
+	Events []cache.EventType `json:"events,omitempty"`
+	// Outlets and Stations restrict the subscription to the given outlets and
+	// the outlets of the given stations; both empty matches every outlet.
+	Outlets  []string `json:"outlets,omitempty"`
+	Stations []string `json:"stations,omitempty"`
+	// Static marks subscriptions from the config file, which cannot be removed
+	// at runtime.
+	Static bool `json:"static,omitempty"`
+}
+
+func (s Subscription) Validate() error {
+	if s.ID == "" {
+		return errors.New("webhook has no id")
+	}
+	u, err := url.Parse(s.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("webhook %q has an invalid url %q", s.ID, s.URL)
+	}
+	for _, event := range s.Events {
+		if !slices.Contains(cache.EventTypes, event) {
+			return fmt.Errorf("webhook %q has an unknown event %q", s.ID, event)
+		}
+	}
+	return nil
+}
+
+func (s Subscription) matches(e Event) bool {
+	if e.Remote && s.Static {
+		return false
+	}
+	events := s.Events
+	if len(events) == 0 {
+		events = DefaultEvents
+	}
+	if !slices.Contains(events, e.Type) {
+		return false
+	}
+	if len(s.Outlets) == 0 && len(s.Stations) == 0 {
+		return true
+	}
+	return slices.Contains(s.Outlets, e.OutletID) || (e.StationID != "" && slices.Contains(s.Stations, e.StationID))
+}
+
+// Event is one typed change of an outlet to deliver.
+type Event struct {
+	Type      cache.EventType
+	OutletID  string
+	StationID string
+	// Outlet is the outlet's status, sent as the payload's outlet field.
+	Outlet any
+	// Remote marks a change made by another replica. Every replica has the
+	// static subscriptions, so only the one that made the change delivers to
+	// them; subscriptions added at runtime live on one replica, which delivers
+	// every change.
+	Remote bool
+}
+
+// Payload is the JSON body POSTed to a webhook.
+type Payload struct {
+	DeliveryID   string          `json:"delivery_id"`
+	Subscription string          `json:"subscription"`
+	Event        cache.EventType `json:"event"`
+	Time         int64           `json:"time"`
+	OutletID     string          `json:"outlet_id"`
+	StationID    string          `json:"station_id,omitempty"`
+	Outlet       any             `json:"outlet"`
+}
+
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	TimestampHeader = "X-Webhook-Timestamp"
+	EventHeader     = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery"
+)
+
+// Sign returns the signature sent in SignatureHeader: the hex HMAC-SHA256 of
+// the timestamp, a dot and the body, keyed with the subscription secret.
+// Receivers should recompute it and reject stale timestamps.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+type Options struct {
+	// Timeout bounds each delivery attempt; defaults to 10 seconds.
+	Timeout time.Duration
+	// Attempts is the number of retries after a failed delivery.
+	Attempts  int
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// DeadLetter is the JSON-lines file failed deliveries are appended to;
+	// empty only logs them.
+	DeadLetter string
+	// Store is the file subscriptions added at runtime are kept in; empty
+	// keeps them in memory only.
+	Store string
+	// Workers is the number of concurrent deliveries; defaults to 2.
+	Workers int
+}
+
+type delivery struct {
+	subscription Subscription
+	payload      Payload
+}
+
+// DeadLetter is a record of the dead-letter log.
+type DeadLetter struct {
+	Time         int64   `json:"time"`
+	Subscription string  `json:"subscription"`
+	URL          string  `json:"url"`
+	Attempts     int     `json:"attempts"`
+	Error        string  `json:"error"`
+	Payload      Payload `json:"payload"`
+}
+
+type Dispatcher struct {
+	opts   Options
+	client *http.Client
+
+	mu            sync.RWMutex
+	subscriptions map[string]Subscription
+	store         *journal.Journal
+
+	queue      chan delivery
+	ctx        context.Context
+	cancel     context.CancelFunc
+	workers    sync.WaitGroup
+	deadLetter *journal.Journal
+	// closed is guarded by mu and stops Dispatch from using the queue.
+	closed    bool
+	closeOnce sync.Once
+}
+
+const queueSize = 1024
+
+// NewDispatcher starts delivering to the static subscriptions and to those
+// previously added at runtime.
+func NewDispatcher(opts Options, static []Subscription) (*Dispatcher, error) {
+	opts.Timeout = cmp.Or(opts.Timeout, 10*time.Second)
+	opts.BaseDelay = cmp.Or(opts.BaseDelay, time.Second)
+	opts.MaxDelay = max(opts.MaxDelay, opts.BaseDelay)
+	opts.Attempts = max(opts.Attempts, 0)
+	opts.Workers = max(opts.Workers, 2)
+	d := &Dispatcher{
+		opts:          opts,
+		client:        &http.Client{Timeout: opts.Timeout},
+		subscriptions: make(map[string]Subscription),
+		queue:         make(chan delivery, queueSize),
+	}
+	for _, s := range static {
+		if err := s.Validate(); err != nil {
+			return nil, err
+		}
+		if _, ok := d.subscriptions[s.ID]; ok {
+			return nil, fmt.Errorf("duplicate webhook id %q", s.ID)
+		}
+		s.Static = true
+		d.subscriptions[s.ID] = s
+	}
+	if opts.Store != "" {
+		store, err := journal.Open(opts.Store, func(line []byte) error {
+			var s Subscription
+			if err := json.Unmarshal(line, &s); err != nil {
+				return err
+			}
+			if _, ok := d.subscriptions[s.ID]; !ok {
+				s.Static = false
+				d.subscriptions[s.ID] = s
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		d.store = store
+	}
+	if opts.DeadLetter != "" {
+		deadLetter, err := journal.Open(opts.DeadLetter, nil)
+		if err != nil {
+			d.closeStore()
+			return nil, err
+		}
+		d.deadLetter = deadLetter
+	}
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+	for range opts.Workers {
+		d.workers.Go(d.work)
+	}
+	return d, nil
+}
+
+// Subscriptions returns every subscription ordered by id.
+func (d *Dispatcher) Subscriptions() []Subscription {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	subscriptions := make([]Subscription, 0, len(d.subscriptions))
+	for _, s := range d.subscriptions {
+		subscriptions = append(subscriptions, s)
+	}
+	slices.SortFunc(subscriptions, func(a, b Subscription) int { return strings.Compare(a.ID, b.ID) })
+	return subscriptions
+}
+
+// Add registers a subscription at runtime.
+func (d *Dispatcher) Add(s Subscription) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	s.Static = false
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.subscriptions[s.ID]; ok {
+		return ErrExists
+	}
+	d.subscriptions[s.ID] = s
+	if err := d.save(); err != nil {
+		delete(d.subscriptions, s.ID)
+		return err
+	}
+	return nil
+}
+
+// Remove unregisters a subscription added at runtime.
+func (d *Dispatcher) Remove(id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s, ok := d.subscriptions[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if s.Static {
+		return ErrStatic
+	}
+	delete(d.subscriptions, id)
+	if err := d.save(); err != nil {
+		d.subscriptions[id] = s
+		return err
+	}
+	return nil
+}
+
+// save must be called with mu held.
+func (d *Dispatcher) save() error {
+	if d.store == nil {
+		return nil
+	}
+	return d.store.Rewrite(func(emit func(v any) error) error {
+		for _, s := range d.subscriptions {
+			if s.Static {
+				continue
+			}
+			if err := emit(s); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Dispatch queues e for every matching subscription without blocking. When
+// the queue is full the delivery goes straight to the dead-letter log.
+func (d *Dispatcher) Dispatch(e Event) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		return
+	}
+	for _, s := range d.subscriptions {
+		if !s.matches(e) {
+			continue
+		}
+		job := delivery{subscription: s, payload: Payload{
+			DeliveryID:   newDeliveryID(),
+			Subscription: s.ID,
+			Event:        e.Type,
+			Time:         time.Now().Unix(),
+			OutletID:     e.OutletID,
+			StationID:    e.StationID,
+			Outlet:       e.Outlet,
+		}}
+		select {
+		case d.queue <- job:
+		default:
+			d.bury(job, 0, errors.New("delivery queue is full"))
+		}
+	}
+}
+
+func (d *Dispatcher) work() {
+	for job := range d.queue {
+		if d.ctx.Err() != nil {
+			d.bury(job, 0, errors.New("shut down before delivery"))
+			continue
+		}
+		d.deliver(job)
+	}
+}
+
+// deliver posts job, retrying with jittered exponential backoff. Once the
+// dispatcher is closed, pending retries are abandoned to the dead-letter log.
+func (d *Dispatcher) deliver(job delivery) {
+	body, err := json.Marshal(job.payload)
+	if err != nil {
+		d.bury(job, 0, err)
+		return
+	}
+	attempt := 0
+	for {
+		err = d.post(job, body)
+		attempt++
+		var permanent *permanentError
+		if err == nil {
+			return
+		}
+		if errors.As(err, &permanent) || attempt > d.opts.Attempts || !d.sleep(d.backoff(attempt-1)) {
+			d.bury(job, attempt, err)
+			return
+		}
+	}
+}
+
+// permanentError is a failure that retrying will not change.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (d *Dispatcher) post(job delivery, body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), d.opts.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, job.subscription.URL, bytes.NewReader(body))
+	if err != nil {
+		return &permanentError{err}
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, string(job.payload.Event))
+	req.Header.Set(DeliveryHeader, job.payload.DeliveryID)
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	if job.subscription.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(job.subscription.Secret, timestamp, body))
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+		resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests:
+		return &permanentError{fmt.Errorf("unexpected status %d", resp.StatusCode)}
+	}
+	return fmt.Errorf("unexpected status %d", resp.StatusCode)
+}
+
+// backoff returns the delay before the given retry (0-based), jittered into
+// the upper half of the capped exponential delay.
+func (d *Dispatcher) backoff(attempt int) time.Duration {
+	delay := d.opts.MaxDelay
+	if attempt < 32 {
+		delay = min(d.opts.BaseDelay<<attempt, d.opts.MaxDelay)
+	}
+	return delay/2 + mathrand.N(delay/2+1)
+}
+
+func (d *Dispatcher) sleep(delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-d.ctx.Done():
+		return false
+	}
+}
+
+func (d *Dispatcher) bury(job delivery, attempts int, err error) {
+	slog.Error("Failed to deliver webhook", "webhook", job.subscription.ID, "event", job.payload.Event, "outletId", job.payload.OutletID, "attempts", attempts, "error", err)
+	if d.deadLetter == nil {
+		return
+	}
+	record := DeadLetter{
+		Time:         time.Now().Unix(),
+		Subscription: job.subscription.ID,
+		URL:          job.subscription.URL,
+		Attempts:     attempts,
+		Error:        err.Error(),
+		Payload:      job.payload,
+	}
+	if err := d.deadLetter.Append(record); err != nil {
+		slog.Error("Failed to write webhook dead-letter log", "error", err)
+	}
+}
+
+func newDeliveryID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// Close stops accepting events, abandons queued deliveries and pending retries
+// to the dead-letter log and waits for the deliveries in flight.
+func (d *Dispatcher) Close() error {
+	var err error
+	d.closeOnce.Do(func() {
+		d.mu.Lock()
+		d.closed = true
+		close(d.queue)
+		d.mu.Unlock()
+		d.cancel()
+		d.workers.Wait()
+		if d.deadLetter != nil {
+			err = d.deadLetter.Close()
+		}
+		err = errors.Join(err, d.closeStore())
+	})
+	return err
+}
+
+func (d *Dispatcher) closeStore() error {
+	if d.store == nil {
+		return nil
+	}
+	return d.store.Close()
+}
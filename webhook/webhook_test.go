@@ -0,0 +1,222 @@
+package webhook
+
+import (
+	"bufio"
+	"charge-monitor/cache"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type received struct {
+	header  http.Header
+	body    []byte
+	payload Payload
+}
+
+// newReceiver answers with the given status codes in turn, then 200.
+func newReceiver(t *testing.T, statuses ...int) (*httptest.Server, chan received, *atomic.Int64) {
+	requests := make(chan received, 16)
+	var calls atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(calls.Add(1))
+		body, _ := io.ReadAll(r.Body)
+		var payload Payload
+		json.Unmarshal(body, &payload)
+		if n <= len(statuses) {
+			w.WriteHeader(statuses[n-1])
+			return
+		}
+		requests <- received{header: r.Header, body: body, payload: payload}
+	}))
+	t.Cleanup(server.Close)
+	return server, requests, &calls
+}
+
+func newTestDispatcher(t *testing.T, opts Options, static ...Subscription) *Dispatcher {
+	opts.BaseDelay = time.Millisecond
+	opts.MaxDelay = 2 * time.Millisecond
+	d, err := NewDispatcher(opts, static)
+	if err != nil {
+		t.Fatalf("NewDispatcher failed: %v", err)
+	}
+	t.Cleanup(func() { d.Close() })
+	return d
+}
+
+func waitReceived(t *testing.T, requests chan received) received {
+	t.Helper()
+	select {
+	case r := <-requests:
+		return r
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for a delivery")
+	}
+	return received{}
+}
+
+func TestDispatcher_DeliversSignedPayload(t *testing.T) {
+	server, requests, _ := newReceiver(t)
+	d := newTestDispatcher(t, Options{}, Subscription{ID: "dashboard", URL: server.URL, Secret: "s3cret", Stations: []string{"xzy-4"}})
+
+	d.Dispatch(Event{Type: cache.EventBecameFree, OutletID: "outlet-1", StationID: "xzct-1"})
+	d.Dispatch(Event{Type: cache.EventBecameBusy, OutletID: "outlet-7", StationID: "xzy-4"})
+	d.Dispatch(Event{Type: cache.EventBecameFree, OutletID: "outlet-7", StationID: "xzy-4", Outlet: map[string]string{"state": "idle"}})
+
+	r := waitReceived(t, requests)
+	if r.payload.OutletID != "outlet-7" || r.payload.Event != cache.EventBecameFree || r.payload.Subscription != "dashboard" {
+		t.Errorf("Unexpected payload %+v", r.payload)
+	}
+	if r.header.Get(EventHeader) != "became_free" || r.header.Get(DeliveryHeader) != r.payload.DeliveryID {
+		t.Errorf("Unexpected headers %v", r.header)
+	}
+	timestamp, _ := strconv.ParseInt(r.header.Get(TimestampHeader), 10, 64)
+	if r.header.Get(SignatureHeader) != Sign("s3cret", timestamp, r.body) {
+		t.Error("Expected a valid signature")
+	}
+	select {
+	case r := <-requests:
+		t.Errorf("Expected a single delivery, got another %+v", r.payload)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestDispatcher_Retries(t *testing.T) {
+	server, requests, calls := newReceiver(t, http.StatusInternalServerError, http.StatusTooManyRequests)
+	d := newTestDispatcher(t, Options{Attempts: 2}, Subscription{ID: "hook", URL: server.URL})
+
+	d.Dispatch(Event{Type: cache.EventFinishedCharging, OutletID: "outlet-7"})
+	waitReceived(t, requests)
+	if n := calls.Load(); n != 3 {
+		t.Errorf("Expected 3 attempts, got %d", n)
+	}
+}
+
+func readDeadLetters(t *testing.T, path string) []DeadLetter {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open dead-letter log: %v", err)
+	}
+	defer file.Close()
+	var records []DeadLetter
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record DeadLetter
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("Invalid dead-letter record: %v", err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestDispatcher_DeadLetter(t *testing.T) {
+	failing, _, failingCalls := newReceiver(t, 500, 500, 500, 500)
+	rejecting, _, rejectingCalls := newReceiver(t, http.StatusGone)
+	path := filepath.Join(t.TempDir(), "dead.jsonl")
+	d := newTestDispatcher(t, Options{Attempts: 2, DeadLetter: path},
+		Subscription{ID: "failing", URL: failing.URL},
+		Subscription{ID: "rejecting", URL: rejecting.URL},
+	)
+
+	d.Dispatch(Event{Type: cache.EventBecameFree, OutletID: "outlet-7"})
+	deadline := time.Now().Add(2 * time.Second)
+	for failingCalls.Load() < 3 || rejectingCalls.Load() < 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the deliveries")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	d.Close()
+
+	if n := rejectingCalls.Load(); n != 1 {
+		t.Errorf("Expected a 4xx response not to be retried, got %d attempts", n)
+	}
+	records := readDeadLetters(t, path)
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 dead letters, got %d", len(records))
+	}
+	for _, record := range records {
+		if record.Payload.OutletID != "outlet-7" || record.Error == "" {
+			t.Errorf("Unexpected dead letter %+v", record)
+		}
+		if record.Subscription == "failing" && record.Attempts != 3 {
+			t.Errorf("Expected 3 attempts before giving up, got %d", record.Attempts)
+		}
+	}
+}
+
+func TestDispatcher_AddAndRemove(t *testing.T) {
+	store := filepath.Join(t.TempDir(), "webhooks.jsonl")
+	static := Subscription{ID: "static", URL: "http://example.com/static"}
+	d := newTestDispatcher(t, Options{Store: store}, static)
+
+	if err := d.Add(Subscription{ID: "bad", URL: "ftp://example.com"}); err == nil {
+		t.Error("Expected an invalid URL to be rejected")
+	}
+	if err := d.Add(Subscription{ID: "bad", URL: "http://example.com", Events: []cache.EventType{"exploded"}}); err == nil {
+		t.Error("Expected an unknown event to be rejected")
+	}
+	if err := d.Add(Subscription{ID: "static", URL: "http://example.com"}); !errors.Is(err, ErrExists) {
+		t.Errorf("Expected ErrExists, got %v", err)
+	}
+	if err := d.Add(Subscription{ID: "added", URL: "http://example.com/added", Static: true}); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if err := d.Remove("static"); !errors.Is(err, ErrStatic) {
+		t.Errorf("Expected ErrStatic, got %v", err)
+	}
+	if err := d.Remove("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	d.Close()
+
+	reopened := newTestDispatcher(t, Options{Store: store}, static)
+	subscriptions := reopened.Subscriptions()
+	if len(subscriptions) != 2 || subscriptions[0].ID != "added" || subscriptions[0].Static || !subscriptions[1].Static {
+		t.Fatalf("Expected the added webhook to survive a restart, got %+v", subscriptions)
+	}
+	if err := reopened.Remove("added"); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	reopened.Close()
+	if subscriptions := newTestDispatcher(t, Options{Store: store}).Subscriptions(); len(subscriptions) != 0 {
+		t.Errorf("Expected the removal to be persisted, got %+v", subscriptions)
+	}
+}
+
+func TestSubscription_DefaultEvents(t *testing.T) {
+	s := Subscription{ID: "hook", URL: "http://example.com", Outlets: []string{"outlet-7"}}
+	for event, expected := range map[cache.EventType]bool{
+		cache.EventBecameFree:       true,
+		cache.EventFinishedCharging: true,
+		cache.EventBecameBusy:       false,
+		cache.EventPowerChanged:     false,
+	} {
+		if got := s.matches(Event{Type: event, OutletID: "outlet-7"}); got != expected {
+			t.Errorf("Expected %s to match %v, got %v", event, expected, got)
+		}
+	}
+	if s.matches(Event{Type: cache.EventBecameFree, OutletID: "outlet-1"}) {
+		t.Error("Expected other outlets not to match")
+	}
+}
+
+func TestSubscription_RemoteEvents(t *testing.T) {
+	remote := Event{Type: cache.EventBecameFree, OutletID: "outlet-7", Remote: true}
+	if (Subscription{ID: "static", Static: true}).matches(remote) {
+		t.Error("Expected static subscriptions to leave remote changes to the replica that made them")
+	}
+	if !(Subscription{ID: "added"}).matches(remote) {
+		t.Error("Expected subscriptions added at runtime to match remote changes")
+	}
+}